@@ -66,23 +66,56 @@ func main() {
 
 	refmers := new(seqmer.Oligos) // create global;
 	refmers.Init(globs.Geti("klen"), globs.Getf("kcountfile"), globs.Getb("printNs"), globs.Geti("kminprint"))
+	refmers.SetCanonical(globs.Getb("canonical")) // a flag in the kinfile header takes precedence
 	refmers.Readk(globs.Getf("kinfile")) // read kmer and counts
 
 	seqs := new(seqmer.Sequences) // create global;
 	seqs.Init(globs.Getf("seqfile"), globs.Geti("minseqlen"), globs.Geti("linelimit"), globs.Geti("minline"), globs.Getb("recordseq"), globs.Gets("filetype"), globs.Getb("dofilter"))
+	meta := new(seqmer.Metadata) // sequence metadata from headers and an optional metadata table
+	meta.Init(globs.Getf("metafile"), globs.Gets("headerpattern"))
+	seqs.SetMetadata(meta)
+	seqs.SetFilter(globs.Getf("seqnamefilter"), globs.Gets("filterdir"), globs.Gets("seqnameregex"), globs.Gets("datefrom"), globs.Gets("dateto"), globs.Gets("countries"))
 	qnkmers := makeKmers(seqs, globs, "qnotk_")
 
 	vars := new(seqmer.Variants) // create global
 	vars.Init(globs.Geti("klen"), globs.Getf("varfile"), globs.Geti("kminprint"))
+	if varmetafile := globs.Getf("varmetafile"); varmetafile != "empty" {
+		vars.Metafile = varmetafile // breakdown of variant counts by time and place
+	}
+	vars.SetPolicy(globs.Gets("npolicy"), globs.Getb("openended")) // as in the tagvars run, so its variants match
 	vars.Read(globs.Getf("varinfile"), globs.Geti("varreadmin"))
 	vars.Print()
 	vars.MatchPrint()
 
 	haps := new(seqmer.Haplotypes) // create global
 	haps.Init(globs.Geti("klen"), globs.Getf("hapfile"), globs.Geti("kminprint"))
+	if hapmetafile := globs.Getf("hapmetafile"); hapmetafile != "empty" {
+		haps.Metafile = hapmetafile // breakdown of haplotype counts by time and place
+	}
+	if memberfile := globs.Getf("memberfile"); memberfile != "empty" {
+		haps.Memberfile = memberfile // which sequence gave which haplotype
+	}
+	store := new(seqmer.Store) // persistent haplotypes across batches, if set
+	if hapdb := globs.Getf("hapdb"); hapdb != "empty" {
+		store.Open(hapdb, globs.Geti("klen"))
+		store.BindVariants(globs.Getf("vardb"), vars) // haplotype variant IDs must be those of the variant store
+		store.AttachHaplotypes(haps)                  // stored haplotypes keep their IDs, new ones are numbered on
+	}
 	vars.Addhaps(haps)                      // add haplotype link to vars
 	seqs.HapBuilder(qnkmers, refmers, vars) // yet another version
 	haps.Print(1)                           // 1 is the basic print mode; we use 2 in hapcombos
+	if vars.Metafile != "" {
+		vars.MetaPrint()
+	}
+	if haps.Metafile != "" {
+		haps.MetaPrint()
+	}
+	if haps.Memberfile != "" {
+		haps.MemberPrint(globs.Gets("memberformat"))
+	}
+	if store.File != "" {
+		store.Append(globs.Getf("seqfile")) // the batch is named for its sequence file
+	}
 
 	// end main code
 
@@ -97,5 +130,7 @@ func makeKmers(seqs *seqmer.Sequences, globs *globals.Params, kmerID string) *se
 	qnkmers := new(seqmer.Oligos)
 	qnkmers.Init(globs.Geti("klen"), globs.Getf("kcountfile"), globs.Getb("printNs"), globs.Geti("kminprint"))
 	qnkmers.Getoutfile(kmerID + globs.Gets("dorevcomp") + "_" + qbasename)
+	qnkmers.SetRevcomp(globs.Getb("orient")) // orient query sequences to the reference strand
+	qnkmers.SetCanonical(globs.Getb("canonical"))
 	return qnkmers
 }
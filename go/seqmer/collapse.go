@@ -0,0 +1,169 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  collapsing error variants // //
+//
+
+// chain spells out the sequence of a variant, from the start of prev to the end of next,
+// or of the deviants where an open ended variant has no prev or next
+func (vinfo *variant) chain() string {
+	kmers := append([]string{vinfo.prev}, vinfo.deviants...)
+	if vinfo.prev == "" {
+		kmers = kmers[1:]
+	}
+	if vinfo.next != "" {
+		kmers = append(kmers, vinfo.next)
+	}
+	chain := []byte(kmers[0])
+	for _, kmer := range kmers[1:] {
+		chain = append(chain, kmer[len(kmer)-1])
+	}
+	return string(chain)
+}
+
+// oneEdit reports whether a and b differ by a single substitution (snp), insertion or deletion (indel)
+func oneEdit(a string, b string) (bool, string) {
+	if len(a) == len(b) {
+		diffs := 0
+		for i := range a {
+			if a[i] != b[i] {
+				diffs++
+			}
+		}
+		return diffs == 1, "snp"
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) != 1 {
+		return false, ""
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	return a[i:] == b[i+1:], "indel"
+}
+
+// Collapse treats variants with a count of at most maxcount as sequencing errors on a more
+// common variant when one differs from them by a single base in its chain from prev to next,
+// shares prev or next, and has at least ratio times the count; the most common such variant
+// becomes the parent. Counts and breakdowns fold up each cluster into the variant at its top,
+// and folded variants are no longer printed; the counts before folding are kept for ClusterPrint.
+// Returns the number of variants folded
+func (vars *Variants) Collapse(maxcount int, ratio float64) int {
+	fmt.Println("Collapsing error variants with counts up to", maxcount, "onto variants with", ratio, "times the count")
+	vars.rawcount = append([]int{}, vars.varcount...)
+	counts := vars.rawcount
+	chains := make([]string, len(vars.varlist))
+	byanchor := make(map[string][]int) // indices of the variants by prev and by next
+	index := make(map[*variant]int)
+	for i, vinfo := range vars.varlist {
+		chains[i] = vinfo.chain()
+		byanchor["prev "+vinfo.prev] = append(byanchor["prev "+vinfo.prev], i)
+		byanchor["next "+vinfo.next] = append(byanchor["next "+vinfo.next], i)
+		index[vinfo] = i
+	}
+
+	folded := 0
+	for i, vinfo := range vars.varlist {
+		if counts[i] > maxcount || vinfo.hasNs() {
+			continue
+		}
+		best := -1
+		for _, key := range []string{"prev " + vinfo.prev, "next " + vinfo.next} {
+			for _, j := range byanchor[key] {
+				if counts[j] <= counts[i] || float64(counts[j]) < ratio*float64(counts[i]) || vars.varlist[j].hasNs() {
+					continue
+				}
+				if best >= 0 && (counts[j] < counts[best] || (counts[j] == counts[best] && j >= best)) {
+					continue // keep the more common, or earlier
+				}
+				if isone, _ := oneEdit(chains[i], chains[j]); isone {
+					best = j
+				}
+			}
+		}
+		if best >= 0 {
+			vinfo.parent = vars.varlist[best]
+			folded++
+		}
+	}
+
+	// fold the least common first, so each count passes all the way up its cluster
+	order := make([]int, len(vars.varlist))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return counts[order[a]] < counts[order[b]] })
+	for _, i := range order {
+		vinfo := vars.varlist[i]
+		if vinfo.parent == nil {
+			continue
+		}
+		parent := vinfo.parent
+		vars.varcount[index[parent]] += vars.varcount[i]
+		vars.varcount[i] = 0
+		if vinfo.meta != nil {
+			if parent.meta == nil {
+				parent.meta = make(map[string]int)
+			}
+			for key, count := range vinfo.meta {
+				parent.meta[key] += count
+			}
+			vinfo.meta = nil
+		}
+	}
+	fmt.Println("error variants folded into their parents", folded)
+	return folded
+}
+
+// top is the variant at the top of the cluster of vinfo
+func (vinfo *variant) top() *variant {
+	for vinfo.parent != nil {
+		vinfo = vinfo.parent
+	}
+	return vinfo
+}
+
+// ClusterPrint outputs the clusters made by Collapse, a line for each folded variant and each
+// variant at the top of a cluster, with its count before folding; IDs are origIDs of the
+// variant output, and the top of a cluster is its own parent and top
+func (vars *Variants) ClusterPrint() {
+	clusterfile := vars.Outfile + "Clusters.xls"
+	fmt.Println("Opening Variant Cluster Output File", clusterfile)
+	fcout, err := os.Create(clusterfile)
+	globals.Check(err)
+	defer fcout.Close()
+	cwriter := bufio.NewWriter(fcout)
+	defer cwriter.Flush() // need this to get output
+
+	intop := make(map[*variant]bool)
+	for _, vinfo := range vars.varlist {
+		if vinfo.parent != nil {
+			intop[vinfo.top()] = true
+		}
+	}
+	fmt.Fprintln(cwriter, "origID\tVID\trawcount\tcount\tparentID\tparentVID\ttopID\ttopVID\tedit")
+	for i, vinfo := range vars.varlist {
+		if vinfo.parent == nil && !intop[vinfo] {
+			continue
+		}
+		parent, top, edit := vinfo, vinfo, "-"
+		if vinfo.parent != nil {
+			parent, top = vinfo.parent, vinfo.top()
+			_, edit = oneEdit(vinfo.chain(), parent.chain())
+		}
+		fmt.Fprintf(cwriter, "%d\t%s\t%d\t%d\t%d\t%s\t%d\t%s\t%s\n", vinfo.ID, vinfo.vid(), vars.rawcount[i], vars.varcount[i],
+			parent.ID, parent.vid(), top.ID, top.vid(), edit)
+	}
+}
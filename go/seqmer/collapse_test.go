@@ -0,0 +1,63 @@
+package seqmer
+
+import (
+	"testing"
+)
+
+func TestOneEdit(t *testing.T) {
+	for _, c := range []struct {
+		a, b   string
+		isone  bool
+		change string
+	}{
+		{"ATCGA", "ATgGA", true, "snp"},
+		{"ATCGA", "AgCGc", false, "snp"},
+		{"ATCGA", "ATCGA", false, "snp"},
+		{"ATCGA", "ATCaGA", true, "indel"},
+		{"ATCGA", "TCGA", true, "indel"},
+		{"ATCGA", "ATCGAAA", false, ""},
+		{"ATCGA", "ATGAC", false, "snp"},
+	} {
+		isone, change := oneEdit(c.a, c.b)
+		if isone != c.isone || (isone && change != c.change) {
+			t.Errorf("oneEdit(%s, %s) is %t %s, not %t %s", c.a, c.b, isone, change, c.isone, c.change)
+		}
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	// chains ATCGAtATGGC (common), ATCGAcATGGC (one base off it), ATCGAccTGGC (two off it,
+	// one off the rare one), and AATCGAtATGGC (an extra base, with another prev)
+	common := []string{"TCGAt", "CGAtA", "GAtAT", "AtATG", "tATGG"}
+	onebase := []string{"TCGAc", "CGAcA", "GAcAT", "AcATG", "cATGG"}
+	twobase := []string{"TCGAc", "CGAcc", "GAccT", "AccTG", "ccTGG"}
+	vars := new(Variants)
+	vars.Init(5, "", 0)
+	for i := 0; i < 20; i++ {
+		findvar(vars, "ATCGA", common, "ATGGC")
+	}
+	findvar(vars, "ATCGA", onebase, "ATGGC")
+	findvar(vars, "ATCGA", onebase, "ATGGC")
+	findvar(vars, "ATCGA", twobase, "ATGGC")
+	findvar(vars, "AATCG", []string{"ATCGA", "TCGAt", "CGAtA", "GAtAT", "AtATG", "tATGG"}, "ATGGC")
+	findvar(vars, "GATAT", []string{"ATATt"}, "TATtG")
+	compare(vars.varlist[0].chain(), "ATCGAtATGGC", t)
+	compare(vars.varlist[4].chain(), "GATATtG", t)
+
+	t.Run("ratio", func(t *testing.T) {
+		compare(vars.Collapse(2, 11), 1, t) // only the extra base, 1 to 20
+		compare(vars.varlist[3].parent, vars.varlist[0], t)
+		vars.varlist[3].parent = nil
+		vars.varcount = append([]int{}, vars.rawcount...)
+	})
+	t.Run("parents", func(t *testing.T) {
+		compare(vars.Collapse(2, 10), 2, t)
+		parents := make([]*variant, 0)
+		for _, vinfo := range vars.varlist {
+			parents = append(parents, vinfo.parent)
+		}
+		compare(parents, []*variant{nil, vars.varlist[0], nil, vars.varlist[0], nil}, t)
+		compare(vars.rawcount, []int{20, 2, 1, 1, 1}, t)
+		compare(vars.varcount, []int{23, 0, 1, 0, 1}, t)
+	})
+}
@@ -0,0 +1,203 @@
+package seqmer
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  haplotype network exports // //
+//
+
+// nodesummary is the time and place summary of a haplotype's metadata breakdown
+type nodesummary struct {
+	first     string // earliest and latest collection dates
+	last      string
+	countries string // country:count, most common first
+}
+
+// summarize reduces a metakey count breakdown to the first and last dates and country counts
+func summarize(meta map[string]int) nodesummary {
+	var summary nodesummary
+	countries := make(map[string]int)
+	for key, count := range meta {
+		fields := strings.Split(key, "\t") // date, region, country, division
+		if date := fields[0]; date != "NA" {
+			if summary.first == "" || date < summary.first {
+				summary.first = date
+			}
+			if date > summary.last {
+				summary.last = date
+			}
+		}
+		if len(fields) > 2 {
+			countries[fields[2]] += count
+		}
+	}
+	names := sortedkeys(countries)
+	sort.SliceStable(names, func(i, j int) bool { return countries[names[i]] > countries[names[j]] })
+	words := make([]string, len(names))
+	for i, name := range names {
+		words[i] = name + ":" + strconv.Itoa(countries[name])
+	}
+	summary.countries = strings.Join(words, ",")
+	return summary
+}
+
+// Export writes the network as a graph in format dot (Graphviz), graphml or json (Cytoscape)
+func (net *Network) Export(graphfile string, format string) {
+	fmt.Println("Opening Network Graph Output File", graphfile, format)
+	fgout, err := os.Create(graphfile)
+	globals.Check(err)
+	defer fgout.Close()
+	gwriter := bufio.NewWriter(fgout)
+	defer gwriter.Flush() // need this to get output
+
+	switch format {
+	case "dot":
+		net.dot(gwriter)
+	case "graphml":
+		net.graphml(gwriter)
+	case "json":
+		net.cytoscape(gwriter)
+	default:
+		fmt.Println("Exiting, unknown graph format", format, "expected dot, graphml or json")
+		os.Exit(1)
+	}
+}
+
+// dot writes Graphviz DOT, edges directed from fewer to more variants
+func (net *Network) dot(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "digraph haplotypes {\n\tgraph [method=%q];\n\tnode [shape=circle];\n", net.Method)
+	for _, hinfo := range net.nodes {
+		summary := summarize(hinfo.meta)
+		fmt.Fprintf(writer, "\th%d [label=%q, count=%d, status=%q, variants=%q", hinfo.ID, hinfo.bitstring, hinfo.hapcount, hinfo.status, idlist(hinfo.variants))
+		if hinfo.meta != nil {
+			fmt.Fprintf(writer, ", first=%q, last=%q, countries=%q", summary.first, summary.last, summary.countries)
+		}
+		fmt.Fprintln(writer, "];")
+	}
+	for _, edge := range net.edges {
+		fmt.Fprintf(writer, "\th%d -> h%d [label=%q, distance=%d, weight=%d, gained=%q, lost=%q];\n", edge.From, edge.To,
+			idlist(edge.Gained), edge.Distance, edge.Weight, idlist(edge.Gained), idlist(edge.Lost))
+	}
+	fmt.Fprintln(writer, "}")
+}
+
+// graphmlkeys are the GraphML attribute declarations: id, element, name, type
+var graphmlkeys = [][4]string{
+	{"d0", "node", "haplo", "string"},
+	{"d1", "node", "count", "int"},
+	{"d2", "node", "status", "string"},
+	{"d3", "node", "variants", "string"},
+	{"d4", "node", "first", "string"},
+	{"d5", "node", "last", "string"},
+	{"d6", "node", "countries", "string"},
+	{"d7", "edge", "distance", "int"},
+	{"d8", "edge", "weight", "int"},
+	{"d9", "edge", "gained", "string"},
+	{"d10", "edge", "lost", "string"},
+}
+
+// xmlescape escapes text for an XML attribute or element
+func xmlescape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// graphml writes GraphML
+func (net *Network) graphml(writer *bufio.Writer) {
+	fmt.Fprintln(writer, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(writer, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	for _, key := range graphmlkeys {
+		fmt.Fprintf(writer, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", key[0], key[1], key[2], key[3])
+	}
+	fmt.Fprintf(writer, "  <graph id=%q edgedefault=\"directed\">\n", net.Method)
+	data := func(key string, value string) {
+		fmt.Fprintf(writer, "      <data key=%q>%s</data>\n", key, xmlescape(value))
+	}
+	for _, hinfo := range net.nodes {
+		fmt.Fprintf(writer, "    <node id=\"h%d\">\n", hinfo.ID)
+		data("d0", hinfo.bitstring)
+		data("d1", strconv.Itoa(hinfo.hapcount))
+		data("d2", hinfo.status)
+		data("d3", idlist(hinfo.variants))
+		if hinfo.meta != nil {
+			summary := summarize(hinfo.meta)
+			data("d4", summary.first)
+			data("d5", summary.last)
+			data("d6", summary.countries)
+		}
+		fmt.Fprintln(writer, "    </node>")
+	}
+	for i, edge := range net.edges {
+		fmt.Fprintf(writer, "    <edge id=\"e%d\" source=\"h%d\" target=\"h%d\">\n", i, edge.From, edge.To)
+		data("d7", strconv.Itoa(edge.Distance))
+		data("d8", strconv.Itoa(edge.Weight))
+		data("d9", idlist(edge.Gained))
+		data("d10", idlist(edge.Lost))
+		fmt.Fprintln(writer, "    </edge>")
+	}
+	fmt.Fprintln(writer, "  </graph>")
+	fmt.Fprintln(writer, "</graphml>")
+}
+
+// cytonode and cytoedge are the data of Cytoscape JSON elements
+type cytonode struct {
+	ID        string `json:"id"`
+	Haplo     string `json:"haplo"`
+	Count     int    `json:"count"`
+	Status    string `json:"status"`
+	Variants  []int  `json:"variants"`
+	First     string `json:"first,omitempty"`
+	Last      string `json:"last,omitempty"`
+	Countries string `json:"countries,omitempty"`
+}
+
+type cytoedge struct {
+	ID       string `json:"id"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Distance int    `json:"distance"`
+	Weight   int    `json:"weight"`
+	Gained   []int  `json:"gained"`
+	Lost     []int  `json:"lost"`
+}
+
+// cytoscape writes Cytoscape.js elements JSON, which Cytoscape desktop also imports
+func (net *Network) cytoscape(writer *bufio.Writer) {
+	type element struct {
+		Data interface{} `json:"data"`
+	}
+	var graph struct {
+		Elements struct {
+			Nodes []element `json:"nodes"`
+			Edges []element `json:"edges"`
+		} `json:"elements"`
+	}
+	graph.Elements.Nodes = make([]element, 0, len(net.nodes))
+	graph.Elements.Edges = make([]element, 0, len(net.edges))
+	for _, hinfo := range net.nodes {
+		summary := summarize(hinfo.meta)
+		node := cytonode{"h" + strconv.Itoa(hinfo.ID), hinfo.bitstring, hinfo.hapcount, hinfo.status, hinfo.variants,
+			summary.first, summary.last, summary.countries}
+		graph.Elements.Nodes = append(graph.Elements.Nodes, element{node})
+	}
+	for i, edge := range net.edges {
+		cedge := cytoedge{"e" + strconv.Itoa(i), "h" + strconv.Itoa(edge.From), "h" + strconv.Itoa(edge.To),
+			edge.Distance, edge.Weight, edge.Gained, edge.Lost}
+		graph.Elements.Edges = append(graph.Elements.Edges, element{cedge})
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", " ")
+	globals.Check(encoder.Encode(graph))
+}
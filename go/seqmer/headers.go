@@ -0,0 +1,375 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  sequence metadata from headers and metadata tables // //
+//
+
+// Seqheader holds the metadata for a sequence, parsed from its name (fasta/fastq header)
+// and/or joined from a metadata table
+// GISAID style names look like hCoV-19/England/MILK-273D665/2021|2021-10-11|2021-10-24
+// with the collection date second; other names just give an accession
+type Seqheader struct {
+	Name      string // the whole header, without the > or @
+	Accession string // first | field, up to any whitespace
+	Date      string // collection date, YYYY[-MM[-DD]], "" if not known
+	Region    string // location hierarchy, "" where not known
+	Country   string // second / field of a virus name
+	Division  string
+	Location  string
+	Lineage   string // eg pango lineage
+	Clade     string
+}
+
+// ParseHeader splits a sequence name into its Seqheader fields
+func ParseHeader(name string) Seqheader {
+	header := Seqheader{Name: name}
+	fields := strings.Split(name, "|")
+	if words := strings.Fields(fields[0]); len(words) > 0 {
+		header.Accession = words[0]
+	}
+	if parts := strings.Split(header.Accession, "/"); len(parts) >= 3 {
+		header.Country = parts[1]
+	}
+	if len(fields) > 1 && isdate(strings.TrimSpace(fields[1])) {
+		header.Date = strings.TrimSpace(fields[1])
+	}
+	return header
+}
+
+// isdate checks for YYYY, YYYY-MM or YYYY-MM-DD, digits only
+func isdate(date string) bool {
+	if len(date) != 4 && len(date) != 7 && len(date) != 10 {
+		return false
+	}
+	for i := 0; i < len(date); i++ {
+		if i == 4 || i == 7 {
+			if date[i] != '-' {
+				return false
+			}
+		} else if date[i] < '0' || date[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// set fills the named field, for pattern groups and table columns
+func (header *Seqheader) set(field string, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	switch field {
+	case "accession":
+		header.Accession = value
+	case "date":
+		if isdate(value) {
+			header.Date = value
+		}
+	case "region":
+		header.Region = value
+	case "country":
+		header.Country = value
+	case "division":
+		header.Division = value
+	case "location":
+		header.Location = value
+	case "lineage":
+		header.Lineage = value
+	case "clade":
+		header.Clade = value
+	case "place": // GISAID Location column, eg Europe / United Kingdom / England
+		if !strings.Contains(value, "/") { // nextstrain location column is just the location
+			header.Location = value
+			return
+		}
+		places := strings.Split(value, "/")
+		fields := []string{"region", "country", "division", "location"}
+		for i := 0; i < len(places) && i < len(fields); i++ {
+			header.set(fields[i], places[i])
+		}
+	}
+}
+
+// merge overwrites header fields with the non-empty fields of other
+func (header *Seqheader) merge(other *Seqheader) {
+	header.set("accession", other.Accession)
+	header.set("date", other.Date)
+	header.set("region", other.Region)
+	header.set("country", other.Country)
+	header.set("division", other.Division)
+	header.set("location", other.Location)
+	header.set("lineage", other.Lineage)
+	header.set("clade", other.Clade)
+}
+
+// metacolumns maps metadata table column names (lower case) onto Seqheader fields
+// GISAID, nextstrain and plain names are all recognized
+var metacolumns = map[string]string{
+	"virus name":       "name",
+	"strain":           "name",
+	"name":             "name",
+	"accession id":     "accession",
+	"gisaid_epi_isl":   "accession",
+	"accession":        "accession",
+	"collection date":  "date",
+	"date":             "date",
+	"location":         "place",
+	"region":           "region",
+	"country":          "country",
+	"division":         "division",
+	"pango lineage":    "lineage",
+	"pango_lineage":    "lineage",
+	"lineage":          "lineage",
+	"clade":            "clade",
+	"nextstrain_clade": "clade",
+}
+
+// Metadata gives the Seqheader for sequence names, using configurable header patterns
+// then overlaying any record for the name (or accession) from a metadata table
+type Metadata struct {
+	patterns []*regexp.Regexp      // named groups are Seqheader fields, eg (?P<date>[0-9-]+)
+	table    map[string]*Seqheader // metadata table records by name and accession
+}
+
+// Init sets up metadata from a tab separated metadata file and a header pattern; "empty" skips either
+func (meta *Metadata) Init(metafile string, pattern string) {
+	meta.table = make(map[string]*Seqheader)
+	if pattern != "empty" {
+		meta.AddPattern(pattern)
+	}
+	if metafile != "empty" {
+		meta.Read(metafile)
+	}
+}
+
+// AddPattern adds a header regular expression whose named groups (accession, date, region,
+// country, division, location, lineage, clade) fill the Seqheader; the first matching pattern is used
+func (meta *Metadata) AddPattern(pattern string) {
+	meta.patterns = append(meta.patterns, regexp.MustCompile(pattern))
+}
+
+// Read joins a tab separated metadata table, with column names on the first line
+func (meta *Metadata) Read(metafile string) {
+	fmt.Println("File to open for metadata is ", metafile)
+	fpin, err := os.Open(metafile)
+	globals.Check(err)
+	defer fpin.Close()
+	scanner := bufio.NewScanner(fpin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // metadata lines can be long
+
+	const splitter = "\t"
+	var columns []string // Seqheader field for each column
+	var linecount int
+	for scanner.Scan() {
+		tokens := strings.Split(scanner.Text(), splitter)
+		if linecount == 0 {
+			columns = make([]string, len(tokens))
+			for i, token := range tokens {
+				columns[i] = metacolumns[strings.ToLower(strings.TrimSpace(token))]
+			}
+		} else {
+			header := new(Seqheader)
+			for i, token := range tokens {
+				if i >= len(columns) {
+					break
+				} else if columns[i] == "name" {
+					header.Name = strings.TrimSpace(token)
+				} else {
+					header.set(columns[i], token)
+				}
+			}
+			if header.Name != "" {
+				meta.table[header.Name] = header
+			}
+			if header.Accession != "" {
+				meta.table[header.Accession] = header
+			}
+		}
+		linecount++
+	}
+	fmt.Println("metadata records read", linecount-1)
+}
+
+// Lookup returns the metadata for a sequence name
+func (meta *Metadata) Lookup(name string) Seqheader {
+	header := ParseHeader(name)
+	if meta == nil {
+		return header
+	}
+	for _, pattern := range meta.patterns {
+		if match := pattern.FindStringSubmatch(name); match != nil {
+			for i, field := range pattern.SubexpNames() {
+				header.set(field, match[i])
+			}
+			break
+		}
+	}
+	if record := meta.table[header.Name]; record != nil {
+		header.merge(record)
+	} else if record := meta.table[header.Accession]; record != nil {
+		header.merge(record)
+	}
+	return header
+}
+
+// metakey is the time and place a sequence is tallied under in breakdowns
+func metakey(header Seqheader) string {
+	fields := []string{header.Date, header.Region, header.Country, header.Division}
+	for i := range fields {
+		if fields[i] == "" {
+			fields[i] = "NA"
+		}
+	}
+	return strings.Join(fields, "\t")
+}
+
+// metaheader names the columns of metakey
+const metaheader = "date\tregion\tcountry\tdivision"
+
+// setsource sets the current sequence, and the time and place its variants and haplotype are tallied under
+func (vars *Variants) setsource(header Seqheader) {
+	vars.source = metakey(header)
+	if vars.Metafile != "" {
+		if vars.sources == nil {
+			vars.sources = make(map[string]int)
+		}
+		vars.sources[vars.source]++
+	}
+	if vars.haps != nil {
+		vars.haps.source = vars.source
+		vars.haps.seqname = header.Name
+		if vars.haps.Metafile != "" {
+			if vars.haps.sources == nil {
+				vars.haps.sources = make(map[string]int)
+			}
+			vars.haps.sources[vars.source]++
+		}
+	}
+}
+
+// tally counts vinfo under the current sequence's time and place, if tracking
+func (vars *Variants) tally(vinfo *variant) {
+	if vars.Metafile != "" && vinfo != nil {
+		if vinfo.meta == nil {
+			vinfo.meta = make(map[string]int)
+		}
+		vinfo.meta[vars.source]++
+	}
+}
+
+// tally counts hinfo under the current sequence's time and place, if tracking
+// the empty haplotype closed at the first header has no sequence, so no place
+func (haps *Haplotypes) tally(hinfo *haplo) {
+	if haps.Metafile != "" && haps.seqname != "" {
+		if hinfo.meta == nil {
+			hinfo.meta = make(map[string]int)
+		}
+		hinfo.meta[haps.source]++
+	}
+}
+
+// MetaPrint outputs the counts of each printed variant by time and place, after the counts
+// of all sequences (ID all), the denominators for frequencies
+// IDs follow Print, so the two files join on VariantID
+func (vars *Variants) MetaPrint() {
+	fmt.Println("Opening Variant Breakdown Output File", vars.Metafile)
+	fvout, err := os.Create(vars.Metafile)
+	globals.Check(err)
+	defer fvout.Close()
+	vwriter := bufio.NewWriter(fvout)
+	defer vwriter.Flush() // need this to get output
+
+	fmt.Fprintln(vwriter, "VariantID\torigID\t"+metaheader+"\tcount")
+	for _, key := range sortedkeys(vars.sources) {
+		fmt.Fprintf(vwriter, "all\tall\t%s\t%d\n", key, vars.sources[key])
+	}
+	hexcount := 0
+	for i := 0; i < len(vars.varlist); i++ {
+		vinfo := vars.varlist[i]
+		if vars.varcount[i] >= vars.minprint && vars.printable(vinfo) {
+			hexcount++
+			for _, key := range sortedkeys(vinfo.meta) {
+				fmt.Fprintf(vwriter, "%d\t%d\t%s\t%d\n", vars.printID(hexcount, vinfo), vinfo.ID, key, vinfo.meta[key])
+			}
+		}
+	}
+}
+
+// MetaPrint outputs the counts of each haplotype by time and place, after the counts
+// of all sequences (ID all), the denominators for frequencies
+func (haps *Haplotypes) MetaPrint() {
+	fmt.Println("Opening Haplotype Breakdown Output File", haps.Metafile)
+	fhout, err := os.Create(haps.Metafile)
+	globals.Check(err)
+	defer fhout.Close()
+	hwriter := bufio.NewWriter(fhout)
+	defer hwriter.Flush() // need this to get output
+
+	fmt.Fprintln(hwriter, "ID\t"+metaheader+"\tcount")
+	for _, key := range sortedkeys(haps.sources) {
+		fmt.Fprintf(hwriter, "all\t%s\t%d\n", key, haps.sources[key])
+	}
+	for _, hinfo := range haps.haplist {
+		for _, key := range sortedkeys(hinfo.meta) {
+			fmt.Fprintf(hwriter, "%d\t%s\t%d\n", hinfo.ID, key, hinfo.meta[key])
+		}
+	}
+}
+
+// sortedkeys returns the keys of a count map in order, so output is stable
+func sortedkeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ReadMeta reads a haplotype breakdown written by MetaPrint back onto the haplotypes, by ID
+func (haps *Haplotypes) ReadMeta(metafile string) {
+	fmt.Println("File to open for haplotype breakdown is ", metafile)
+	fpin, err := os.Open(metafile)
+	globals.Check(err)
+	defer fpin.Close()
+	scanner := bufio.NewScanner(fpin)
+
+	byID := make(map[int]*haplo)
+	for _, hinfo := range haps.haplist {
+		byID[hinfo.ID] = hinfo
+	}
+	nkey := len(strings.Split(metaheader, "\t"))
+	var linecount, unmatched int
+	for scanner.Scan() {
+		tokens := strings.Split(scanner.Text(), "\t")
+		if linecount > 0 && len(tokens) == nkey+2 && tokens[0] != "all" { // all is the sequence totals
+			ID, err := strconv.Atoi(tokens[0])
+			globals.Check(err)
+			count, err := strconv.Atoi(tokens[nkey+1])
+			globals.Check(err)
+			if hinfo := byID[ID]; hinfo != nil {
+				if hinfo.meta == nil {
+					hinfo.meta = make(map[string]int)
+				}
+				hinfo.meta[strings.Join(tokens[1:nkey+1], "\t")] += count
+			} else {
+				unmatched++
+			}
+		}
+		linecount++
+	}
+	fmt.Println("breakdown lines read, and for unknown haplotypes", linecount-1, unmatched)
+}
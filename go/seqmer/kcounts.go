@@ -0,0 +1,269 @@
+package seqmer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  binary kmer count files // //
+//
+
+// A binary .kcounts file is laid out as
+//	magic     "AVKC" and a version byte
+//	k         uvarint
+//	flags     byte, bit 0 set if counts are canonical
+//	alphabet  uvarint length then the bases, in 2-bit code order
+//	checksum  uint32 little endian, crc32 of the source the counts came from
+//	n         uvarint number of kmers
+//	kmers     n kmers, 2 bits per base packed into (k+3)/4 bytes big endian, sorted
+//	counts    n uvarints, in kmer order
+// kmers with bases outside the alphabet (eg N) are not stored; lower case (soft masked)
+// bases are stored upper case, pooling the counts of kmers that differ only in case
+
+// kbinmagic starts every binary .kcounts file, the last byte is the version
+const kbinmagic = "AVKC\x01"
+
+// kalphabet is the order of the 2-bit base codes, so packed kmers sort as the strings do
+const kalphabet = "ACGT"
+
+// maxpackk is the longest kmer that packs into a uint64
+const maxpackk = 32
+
+// KIndex holds the sorted kmers and counts of a binary .kcounts file for lookups
+type KIndex struct {
+	K         int
+	Canonical bool
+	Alphabet  string
+	Checksum  uint32
+	kmers     []uint64
+	counts    []int
+}
+
+// packmer returns the 2-bit code of kmer, either case, false if it has bases outside the alphabet
+func packmer(kmer string) (uint64, bool) {
+	var code uint64
+	if len(kmer) > maxpackk {
+		return 0, false
+	}
+	for i := 0; i < len(kmer); i++ {
+		switch kmer[i] {
+		case 'A', 'a':
+			code = code << 2
+		case 'C', 'c':
+			code = code<<2 | 1
+		case 'G', 'g':
+			code = code<<2 | 2
+		case 'T', 't':
+			code = code<<2 | 3
+		default:
+			return 0, false
+		}
+	}
+	return code, true
+}
+
+// unpackmer turns a 2-bit code back into a kmer of length k
+func unpackmer(code uint64, k int, alphabet string) string {
+	kbits := make([]byte, k)
+	for i := k - 1; i >= 0; i-- {
+		kbits[i] = alphabet[code&3]
+		code = code >> 2
+	}
+	return string(kbits)
+}
+
+// IsKbinary checks whether kcountfile starts with the binary .kcounts magic
+func IsKbinary(kcountfile string) bool {
+	fpin, err := os.Open(kcountfile)
+	globals.Check(err)
+	defer fpin.Close()
+	magic := make([]byte, len(kbinmagic))
+	n, _ := fpin.Read(magic)
+	return n == len(kbinmagic) && string(magic) == kbinmagic
+}
+
+// Kbinprint outputs kmer counts in the binary .kcounts format
+func (kmers *Oligos) Kbinprint() {
+	fmt.Println("Opening Binary Kmer Count Output File", kmers.Outfile)
+	if kmers.klen > maxpackk {
+		fmt.Println("Exiting, binary kcounts hold kmers up to length", maxpackk, "not", kmers.klen)
+		os.Exit(1)
+	}
+	fkout, err := os.Create(kmers.Outfile)
+	globals.Check(err)
+	defer fkout.Close()
+	kwriter := bufio.NewWriter(fkout)
+	defer kwriter.Flush() // need this to get output
+
+	codes := make([]uint64, 0, len(kmers.kcount))
+	counts := make(map[uint64]int, len(kmers.kcount))
+	unpacked := 0
+	for kmer, kcount := range kmers.kcount {
+		if kcount >= kmers.minprint {
+			if code, ok := packmer(kmer); ok {
+				if _, seen := counts[code]; !seen {
+					codes = append(codes, code)
+				}
+				counts[code] += kcount // soft masked kmers pool with their upper case form
+			} else {
+				unpacked++
+			}
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	fmt.Println("binary kmers written, and skipped for bases outside", kalphabet, len(codes), unpacked)
+
+	var flags byte
+	if kmers.canonical {
+		flags = 1
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	putuvarint := func(x uint64) {
+		kwriter.Write(varint[:binary.PutUvarint(varint, x)])
+	}
+	kwriter.WriteString(kbinmagic)
+	putuvarint(uint64(kmers.klen))
+	kwriter.WriteByte(flags)
+	putuvarint(uint64(len(kalphabet)))
+	kwriter.WriteString(kalphabet)
+	binary.Write(kwriter, binary.LittleEndian, kmers.checksum)
+	putuvarint(uint64(len(codes)))
+	nbytes := (kmers.klen + 3) / 4
+	packed := make([]byte, 8)
+	for _, code := range codes {
+		binary.BigEndian.PutUint64(packed, code<<uint(64-8*nbytes))
+		kwriter.Write(packed[:nbytes])
+	}
+	for _, code := range codes {
+		putuvarint(uint64(counts[code]))
+	}
+}
+
+// ReadKIndex loads a binary .kcounts file whole into memory
+func ReadKIndex(kcountfile string) *KIndex {
+	fmt.Println("File to open for ReadKIndex() is ", kcountfile)
+	data, err := ioutil.ReadFile(kcountfile)
+	globals.Check(err)
+	if !bytes.HasPrefix(data, []byte(kbinmagic)) {
+		fmt.Println("Exiting, not a binary kcounts file", kcountfile)
+		os.Exit(1)
+	}
+	reader := bytes.NewReader(data[len(kbinmagic):])
+	kx := new(KIndex)
+
+	k, err := binary.ReadUvarint(reader)
+	globals.Check(err)
+	if k < 1 || k > maxpackk {
+		globals.Check(fmt.Errorf("%s: kmer length %d is not 1 to %d", kcountfile, k, maxpackk))
+	}
+	kx.K = int(k)
+	flags, err := reader.ReadByte()
+	globals.Check(err)
+	kx.Canonical = flags&1 == 1
+	alen, err := binary.ReadUvarint(reader)
+	globals.Check(err)
+	if alen != uint64(len(kalphabet)) {
+		globals.Check(fmt.Errorf("%s: alphabet of %d bases, not %d", kcountfile, alen, len(kalphabet)))
+	}
+	alphabet := make([]byte, alen)
+	_, err = io.ReadFull(reader, alphabet)
+	globals.Check(err)
+	kx.Alphabet = string(alphabet)
+	globals.Check(binary.Read(reader, binary.LittleEndian, &kx.Checksum))
+	n, err := binary.ReadUvarint(reader)
+	globals.Check(err)
+
+	// each kmer takes nbytes and each count at least a byte, so a truncated
+	// file or a corrupt n is caught before allocating
+	nbytes := (kx.K + 3) / 4
+	if n > uint64(reader.Len())/uint64(nbytes+1) {
+		globals.Check(fmt.Errorf("%s: %d kmers do not fit in the %d bytes left", kcountfile, n, reader.Len()))
+	}
+	packed := make([]byte, 8)
+	kx.kmers = make([]uint64, n)
+	for i := range kx.kmers {
+		_, err = io.ReadFull(reader, packed[:nbytes])
+		globals.Check(err)
+		kx.kmers[i] = binary.BigEndian.Uint64(packed) >> uint(64-8*nbytes)
+	}
+	kx.counts = make([]int, n)
+	for i := range kx.counts {
+		count, err := binary.ReadUvarint(reader)
+		globals.Check(err)
+		kx.counts[i] = int(count)
+	}
+	fmt.Println("kmers in ReadKIndex, k and canonical", n, kx.K, kx.Canonical)
+	return kx
+}
+
+// Len returns the number of kmers in the index
+func (kx *KIndex) Len() int {
+	return len(kx.kmers)
+}
+
+// Kmer returns the i'th kmer in sorted order and its count
+func (kx *KIndex) Kmer(i int) (string, int) {
+	return unpackmer(kx.kmers[i], kx.K, kx.Alphabet), kx.counts[i]
+}
+
+// Count returns the count of kmer by binary search, 0 if it is absent
+func (kx *KIndex) Count(kmer string) int {
+	if kx.Canonical {
+		kmer = canon(kmer)
+	}
+	code, ok := packmer(kmer)
+	if !ok || len(kmer) != kx.K {
+		return 0
+	}
+	i := sort.Search(len(kx.kmers), func(i int) bool { return kx.kmers[i] >= code })
+	if i < len(kx.kmers) && kx.kmers[i] == code {
+		return kx.counts[i]
+	}
+	return 0
+}
+
+// readkbinary fills kmers from a binary .kcounts file, checking k matches
+func (kmers *Oligos) readkbinary(kcountfile string) {
+	kx := ReadKIndex(kcountfile)
+	kmers.checkklen(kx.K, kcountfile)
+	kmers.canonical = kx.Canonical
+	kmers.checksum = kx.Checksum
+	for i := 0; i < kx.Len(); i++ {
+		kmers.addk(kx.Kmer(i))
+	}
+}
+
+// checkklen sets klen from a kcounts file if unset, otherwise exits on a mismatch
+func (kmers *Oligos) checkklen(klen int, kcountfile string) {
+	if kmers.klen == 0 {
+		kmers.klen = klen
+	} else if kmers.klen != klen {
+		fmt.Println("Exiting, kmer length in", kcountfile, "is", klen, "but klen is", kmers.klen)
+		os.Exit(1)
+	}
+}
+
+// KcountsToBinary converts a tab separated .kcounts file to the binary format
+func KcountsToBinary(tsvfile string, binfile string) {
+	kmers := new(Oligos)
+	kmers.Init(0, binfile, true, 0) // klen taken from the file
+	kmers.Readk(tsvfile)
+	kmers.Kbinprint()
+}
+
+// KcountsToTSV converts a binary .kcounts file to the tab separated format
+func KcountsToTSV(binfile string, tsvfile string) {
+	kmers := new(Oligos)
+	kmers.Init(0, tsvfile, true, 0) // klen taken from the file
+	kmers.Readk(binfile)
+	kmers.Kprint()
+}
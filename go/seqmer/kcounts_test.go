@@ -0,0 +1,133 @@
+package seqmer
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// panics reports whether f panics, as globals.Check does on a bad file
+func panics(f func()) (panicked bool) {
+	defer func() { panicked = recover() != nil }()
+	f()
+	return false
+}
+
+// kbinfile writes the reference kmers of testref to a binary .kcounts file
+func kbinfile(t *testing.T) (string, *Oligos) {
+	refmers := refkmers(testref, 5, false)
+	refmers.Outfile = filepath.Join(t.TempDir(), "ref.kcounts")
+	refmers.Kbinprint()
+	return refmers.Outfile, refmers
+}
+
+func TestReadKIndex(t *testing.T) {
+	binfile, refmers := kbinfile(t)
+	data, err := ioutil.ReadFile(binfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("whole", func(t *testing.T) {
+		kx := ReadKIndex(binfile)
+		compare(kx.Len(), refmers.Size(), t)
+		compare(kx.Count("GCGCG"), refmers.Count("GCGCG"), t)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		// cut in the alphabet, the kmers and the counts
+		for _, cut := range []int{10, 20, len(data) - refmers.Size()} {
+			short := filepath.Join(t.TempDir(), "short.kcounts")
+			if err := ioutil.WriteFile(short, data[:cut], 0644); err != nil {
+				t.Fatal(err)
+			}
+			if !panics(func() { ReadKIndex(short) }) {
+				t.Errorf("file cut at %d of %d bytes was read", cut, len(data))
+			}
+		}
+	})
+	t.Run("corrupt kmer count", func(t *testing.T) {
+		// n follows k, flags, alphabet and checksum; a terabyte of kmers must
+		// be caught before allocating them
+		at := len(kbinmagic) + 11
+		varint := make([]byte, binary.MaxVarintLen64)
+		corrupt := append([]byte{}, data[:at]...)
+		corrupt = append(corrupt, varint[:binary.PutUvarint(varint, 1<<40)]...)
+		corrupt = append(corrupt, data[at+1:]...)
+		bad := filepath.Join(t.TempDir(), "corrupt.kcounts")
+		if err := ioutil.WriteFile(bad, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !panics(func() { ReadKIndex(bad) }) {
+			t.Errorf("file claiming 2^40 kmers was read")
+		}
+	})
+}
+
+func TestKbinCase(t *testing.T) {
+	// soft masked kmers are kept, upper cased, through the binary format
+	dir := t.TempDir()
+	tsvfile := filepath.Join(dir, "masked.kcounts")
+	err := ioutil.WriteFile(tsvfile, []byte("kmer\tcount\nACGTA\t2\nacgta\t3\nttnaa\t4\ngattc\t5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binfile := filepath.Join(dir, "masked.bin.kcounts")
+	KcountsToBinary(tsvfile, binfile)
+	kx := ReadKIndex(binfile)
+	kmers := make(map[string]int)
+	for i := 0; i < kx.Len(); i++ {
+		kmer, count := kx.Kmer(i)
+		kmers[kmer] = count
+	}
+	compare(kmers, map[string]int{"ACGTA": 5, "GATTC": 5}, t) // the N kmer is not stored
+	compare(kx.Count("gattc"), 5, t)
+}
+
+func TestKprint(t *testing.T) {
+	dir := t.TempDir()
+	// lines of a kcounts file, the kmers sorted as Kprint writes them in map order
+	lines := func(kcountfile string) []string {
+		out, err := ioutil.ReadFile(kcountfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		header := 0
+		for strings.HasPrefix(lines[header], "#") || strings.HasPrefix(lines[header], "kmer\t") {
+			header++
+		}
+		sort.Strings(lines[header:])
+		return lines
+	}
+	reprint := func(kcountfile string, outfile string) *Oligos {
+		kmers := new(Oligos)
+		kmers.Init(0, outfile, true, 0)
+		kmers.Readk(kcountfile)
+		kmers.Kprint()
+		return kmers
+	}
+
+	t.Run("old format", func(t *testing.T) {
+		oldfile := filepath.Join(dir, "old.kcounts")
+		old := "kmer\tcount\nACGTA\t5\nCCCCC\t1\nGATTA\t12\n"
+		if err := ioutil.WriteFile(oldfile, []byte(old), 0644); err != nil {
+			t.Fatal(err)
+		}
+		kmers := reprint(oldfile, filepath.Join(dir, "new.kcounts"))
+		compare(kmers.klen, 5, t)
+		compare(lines(kmers.Outfile), lines(oldfile), t)
+	})
+	t.Run("canonical", func(t *testing.T) {
+		canonfile := filepath.Join(dir, "canon.kcounts")
+		refmers := refkmers(testref, 5, true)
+		refmers.Outfile = canonfile
+		refmers.Kprint()
+		compare(lines(canonfile)[:2], []string{"# canonical=true", "kmer\tcount"}, t)
+		kmers := reprint(canonfile, filepath.Join(dir, "canon2.kcounts"))
+		compare(kmers.canonical, true, t)
+		compare(lines(kmers.Outfile), lines(canonfile), t)
+	})
+}
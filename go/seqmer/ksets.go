@@ -0,0 +1,200 @@
+package seqmer
+
+import (
+	"fmt"
+	"os"
+)
+
+//
+// //  kmer set algebra // //
+//
+
+// Kcombiner returns the function used to combine the counts of a kmer found in
+// more than one set: "sum", "min" or "max"; nil for anything else
+func Kcombiner(name string) func(int, int) int {
+	switch name {
+	case "sum":
+		return func(a int, b int) int { return a + b }
+	case "min":
+		return func(a int, b int) int {
+			if b < a {
+				return b
+			}
+			return a
+		}
+	case "max":
+		return func(a int, b int) int {
+			if b > a {
+				return b
+			}
+			return a
+		}
+	}
+	return nil
+}
+
+// ReadKcounts reads a tab separated or binary .kcounts file into a new Oligos, k from the file
+func ReadKcounts(kcountfile string) *Oligos {
+	kmers := new(Oligos)
+	kmers.Init(0, "", true, 0)
+	kmers.Readk(kcountfile)
+	return kmers
+}
+
+// Has reports whether kmer (or its canonical form) has a count in kmers
+func (kmers *Oligos) Has(kmer string) bool {
+	_, ok := kmers.kcount[kmers.key(kmer)]
+	return ok
+}
+
+// Size returns the number of distinct kmers
+func (kmers *Oligos) Size() int {
+	return len(kmers.kcount)
+}
+
+// Count returns the count of kmer (or its canonical form), 0 if absent
+func (kmers *Oligos) Count(kmer string) int {
+	return kmers.kcount[kmers.key(kmer)]
+}
+
+// Kthreshold removes kmers with counts below kmin, or above kmax if kmax > 0
+func (kmers *Oligos) Kthreshold(kmin int, kmax int) {
+	for kmer, kcount := range kmers.kcount {
+		if kcount < kmin || (kmax > 0 && kcount > kmax) {
+			delete(kmers.kcount, kmer)
+			if kinfo := kmers.kmap[kmer]; kinfo != nil {
+				delete(kmers.rmap, kinfo.revcomp)
+				delete(kmers.kmap, kmer)
+			}
+		}
+	}
+}
+
+// kfilter adds the counts of the kmers of from that pass keep to kmers
+func (kmers *Oligos) kfilter(from *Oligos, keep func(kmer string) bool) {
+	for kmer, kcount := range from.kcount {
+		if keep(kmer) {
+			kmers.addk(kmer, kcount)
+		}
+	}
+}
+
+// newset makes an empty Oligos with the same k and canonical mode as like, printing all kmers
+func newset(like *Oligos) *Oligos {
+	kmers := new(Oligos)
+	kmers.Init(like.klen, "", true, 0)
+	kmers.canonical = like.canonical
+	return kmers
+}
+
+// Kcanonical returns a copy of kmers in canonical mode, the counts of each kmer and
+// its reverse complement pooled
+func (kmers *Oligos) Kcanonical() *Oligos {
+	canonical := newset(kmers)
+	canonical.canonical = true
+	canonical.kfilter(kmers, func(kmer string) bool { return true })
+	return canonical
+}
+
+// kcompatible exits unless all the sets share k and canonical mode
+func kcompatible(sets []*Oligos) {
+	for _, kmers := range sets[1:] {
+		if kmers.klen != sets[0].klen || kmers.canonical != sets[0].canonical {
+			fmt.Println("Exiting, kmer sets differ in k or canonical mode", sets[0].kfile, kmers.kfile)
+			os.Exit(1)
+		}
+	}
+}
+
+// Kunion returns all kmers in any of the sets, counts combined across the sets holding them
+func Kunion(combine func(int, int) int, sets ...*Oligos) *Oligos {
+	kcompatible(sets)
+	union := newset(sets[0])
+	for _, kmers := range sets {
+		for kmer, kcount := range kmers.kcount {
+			if union.Has(kmer) {
+				union.kcount[kmer] = combine(union.kcount[kmer], kcount)
+				union.kmap[kmer].kcount = union.kcount[kmer]
+			} else {
+				union.addk(kmer, kcount)
+			}
+		}
+	}
+	return union
+}
+
+// Kintersect returns the kmers found in every set, counts combined across the sets
+func Kintersect(combine func(int, int) int, sets ...*Oligos) *Oligos {
+	kcompatible(sets)
+	inter := newset(sets[0])
+	inter.kfilter(sets[0], func(kmer string) bool {
+		for _, kmers := range sets[1:] {
+			if !kmers.Has(kmer) {
+				return false
+			}
+		}
+		return true
+	})
+	for _, kmers := range sets[1:] {
+		for kmer := range inter.kcount {
+			inter.kcount[kmer] = combine(inter.kcount[kmer], kmers.kcount[kmer])
+			inter.kmap[kmer].kcount = inter.kcount[kmer]
+		}
+	}
+	return inter
+}
+
+// Kdiff returns the kmers of the first set found in none of the others, with their first set counts
+func Kdiff(sets ...*Oligos) *Oligos {
+	kcompatible(sets)
+	diff := newset(sets[0])
+	diff.kfilter(sets[0], func(kmer string) bool {
+		for _, kmers := range sets[1:] {
+			if kmers.Has(kmer) {
+				return false
+			}
+		}
+		return true
+	})
+	return diff
+}
+
+// Ksymdiff returns the kmers found in exactly one of the sets, with their counts there
+func Ksymdiff(sets ...*Oligos) *Oligos {
+	kcompatible(sets)
+	symdiff := newset(sets[0])
+	for i, kmers := range sets {
+		symdiff.kfilter(kmers, func(kmer string) bool {
+			for j, other := range sets {
+				if j != i && other.Has(kmer) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return symdiff
+}
+
+// Kcontains finds the kmers that contain any of the shorter qmers as a substring
+// returns those kmers with their counts, and the qmers found with the number of
+// times they occur within them; the sets must share canonical mode, so that a qmer
+// on the other strand of a canonical kmer is found as its canonical form
+func Kcontains(kmers *Oligos, qmers *Oligos) (*Oligos, *Oligos) {
+	if kmers.canonical != qmers.canonical || qmers.klen > kmers.klen {
+		fmt.Println("Exiting, qmers must be no longer than kmers and share canonical mode", kmers.kfile, qmers.kfile)
+		os.Exit(1)
+	}
+	kqmers := newset(kmers)
+	qkmers := newset(qmers)
+	for kmer, kcount := range kmers.kcount {
+		for i := 0; i < (kmers.klen - qmers.klen + 1); i++ {
+			submer := kmer[i : i+qmers.klen]
+			if qmers.Has(submer) {
+				kqmers.kcount[kmer] = kcount
+				qkmers.kcount[qmers.key(submer)]++
+			}
+		}
+	}
+	return kqmers, qkmers
+}
@@ -0,0 +1,81 @@
+package seqmer
+
+import (
+	"sort"
+	"testing"
+)
+
+// testhaps makes haplotypes of the variant lists, each with count
+func testhaps(count int, varlists ...[]int) *Haplotypes {
+	haps := new(Haplotypes)
+	haps.Init(0, "", 0)
+	for i, variants := range varlists {
+		hinfo := new(haplo)
+		hinfo.Init()
+		hinfo.ID = i + 1
+		hinfo.hapcount = count
+		hinfo.variants = variants
+		hinfo.varsToBits()
+		hinfo.bitstring = hinfo.bitset.String()
+		haps.haplist = append(haps.haplist, hinfo)
+		haps.hapset[hinfo.bitstring] = hinfo
+	}
+	return haps
+}
+
+func childIDs(hinfo *haplo) []int {
+	IDs := make([]int, 0)
+	for _, child := range hinfo.children {
+		IDs = append(IDs, child.ID)
+	}
+	sort.Ints(IDs)
+	return IDs
+}
+
+func TestLattice(t *testing.T) {
+	// 1 is shared by all 6 pairs, 1 and 2 only by the first two haplotypes
+	varlists := [][]int{{1, 2, 3}, {1, 2, 4}, {1, 5}, {1, 6}}
+
+	t.Run("observed only", func(t *testing.T) {
+		haps := testhaps(100, varlists...)
+		haps.Lattice(10, false, 0)
+		compare(len(haps.haplist), 4, t)
+		compare(childIDs(haps.haplist[0]), []int{}, t)
+	})
+	t.Run("inferred", func(t *testing.T) {
+		haps := testhaps(100, varlists...)
+		haps.Lattice(10, true, 0)
+		compare(len(haps.haplist), 6, t)
+		root, pair := haps.hapset[haps.haplist[4].bitstring], haps.hapset[haps.haplist[5].bitstring]
+		compare([]int{root.ID, len(root.variants), root.lineage_count, root.descend_count}, []int{5, 1, 4, 400}, t)
+		compare([]int{pair.ID, len(pair.variants), pair.lineage_count}, []int{6, 2, 2}, t)
+		compare(childIDs(root), []int{3, 4, 6}, t)
+		compare(childIDs(pair), []int{1, 2}, t)
+		compare(len(root.descends), 5, t)
+	})
+	t.Run("capped", func(t *testing.T) {
+		haps := testhaps(100, varlists...)
+		haps.Lattice(10, true, 1)
+		compare(len(haps.haplist), 5, t)
+		root := haps.haplist[4]
+		compare(root.variants, []int{1}, t)
+		compare(childIDs(root), []int{1, 2, 3, 4}, t)
+	})
+	t.Run("hapmincombo", func(t *testing.T) {
+		haps := testhaps(100, varlists...)
+		haps.haplist[3].hapcount = 5
+		haps.Lattice(10, true, 0)
+		compare([]int{haps.haplist[4].lineage_count, haps.haplist[5].lineage_count}, []int{3, 2}, t)
+	})
+	t.Run("rare haplotype inferred", func(t *testing.T) {
+		// the shared variant 1 was itself seen, but under hapmincombo
+		haps := testhaps(100, append(varlists, []int{1})...)
+		haps.haplist[4].hapcount = 5
+		haps.Lattice(10, true, 0)
+		compare(len(haps.haplist), 6, t)
+		root := haps.haplist[4]
+		compare(root.status, "stub", t)
+		compare([]int{root.hapcount, root.lineage_count, root.descend_count}, []int{5, 4, 400}, t)
+		compare(childIDs(root), []int{3, 4, 6}, t)
+	})
+}
@@ -0,0 +1,130 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  variant linkage // //
+//
+
+// Linkpair holds the co-occurrence and linkage disequilibrium of two variants over haplotype counts
+type Linkpair struct {
+	A, B      int // variant IDs, A < B
+	CountA    int // sequences carrying each variant, and both
+	CountB    int
+	CountAB   int
+	Total     int     // sequences over all haplotypes
+	Dprime    float64 // D/Dmax, signed
+	Rsquared  float64
+	positionA int
+	positionB int
+}
+
+// Linkage computes D' and r squared for every pair of variants with frequencies of at least minfreq,
+// weighting each haplotype by its count; positions, which may be nil, place the variants, and with
+// a window (from or to over 0, to 0 for no limit) only variants placed within it are used
+func (haps *Haplotypes) Linkage(minfreq float64, positions map[int]int, from int, to int) []*Linkpair {
+	fmt.Println("I am about to compute variant linkage.", minfreq, from, to, len(haps.haplist))
+	total := 0
+	counts := make(map[int]int)
+	for _, hinfo := range haps.haplist {
+		total += hinfo.hapcount
+		for _, vID := range hinfo.variants {
+			counts[vID] += hinfo.hapcount
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	kept := make(map[int]bool)
+	for vID, count := range counts {
+		inwindow := true
+		if from > 0 || to > 0 {
+			position, ok := positions[vID]
+			inwindow = ok && position >= from && (to <= 0 || position <= to)
+		}
+		if inwindow && float64(count)/float64(total) >= minfreq {
+			kept[vID] = true
+		}
+	}
+	variants := make([]int, 0, len(kept))
+	for vID := range kept {
+		variants = append(variants, vID)
+	}
+	sort.Ints(variants)
+
+	// co-occurrence from the haplotypes carrying both
+	together := make(map[[2]int]int)
+	for _, hinfo := range haps.haplist {
+		carried := make([]int, 0)
+		for _, vID := range hinfo.variants {
+			if kept[vID] {
+				carried = append(carried, vID)
+			}
+		}
+		sort.Ints(carried)
+		for i := 0; i < len(carried); i++ {
+			for j := i + 1; j < len(carried); j++ {
+				together[[2]int{carried[i], carried[j]}] += hinfo.hapcount
+			}
+		}
+	}
+
+	pairs := make([]*Linkpair, 0)
+	for i := 0; i < len(variants); i++ {
+		for j := i + 1; j < len(variants); j++ {
+			a, b := variants[i], variants[j]
+			pair := &Linkpair{A: a, B: b, CountA: counts[a], CountB: counts[b], CountAB: together[[2]int{a, b}], Total: total}
+			pair.Dprime, pair.Rsquared = disequilibrium(pair)
+			if positions != nil {
+				pair.positionA, pair.positionB = positions[a], positions[b]
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	fmt.Println("variants and pairs in linkage", len(variants), len(pairs))
+	return pairs
+}
+
+// disequilibrium returns D' and r squared for a pair, 0 where a variant is fixed
+func disequilibrium(pair *Linkpair) (float64, float64) {
+	pA := float64(pair.CountA) / float64(pair.Total)
+	pB := float64(pair.CountB) / float64(pair.Total)
+	pAB := float64(pair.CountAB) / float64(pair.Total)
+	D := pAB - pA*pB
+	var Dmax float64
+	if D > 0 {
+		Dmax = math.Min(pA*(1-pB), (1-pA)*pB)
+	} else {
+		Dmax = math.Min(pA*pB, (1-pA)*(1-pB))
+	}
+	variance := pA * (1 - pA) * pB * (1 - pB)
+	if Dmax == 0 || variance == 0 {
+		return 0, 0
+	}
+	return D / Dmax, D * D / variance
+}
+
+// LinkagePrint outputs variant pairs in long format, one pair per line
+func LinkagePrint(linkfile string, pairs []*Linkpair) {
+	fmt.Println("Opening Linkage Output File", linkfile)
+	flout, err := os.Create(linkfile)
+	globals.Check(err)
+	defer flout.Close()
+	lwriter := bufio.NewWriter(flout)
+	defer lwriter.Flush() // need this to get output
+
+	fmt.Fprintln(lwriter, "varA\tvarB\tposA\tposB\tcountA\tcountB\tcountAB\ttotal\tDprime\tr2")
+	for _, pair := range pairs {
+		fmt.Fprintf(lwriter, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.4f\t%.4f\n", pair.A, pair.B, pair.positionA, pair.positionB,
+			pair.CountA, pair.CountB, pair.CountAB, pair.Total, pair.Dprime, pair.Rsquared)
+	}
+}
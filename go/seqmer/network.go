@@ -0,0 +1,272 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	globals "AnVir/globals"
+	bitsy "github.com/yourbasic/bit"
+)
+
+//
+// //  haplotype networks // //
+//
+
+// Hapedge joins two haplotypes in a network, directed from the haplotype with fewer variants
+// Gained are the variants in To but not From, Lost those in From but not To
+type Hapedge struct {
+	From     int // haplotype IDs
+	To       int
+	Distance int // size of the symmetric difference of the variant sets
+	Weight   int // observed counts of the two haplotypes
+	Gained   []int
+	Lost     []int
+	from     *haplo
+	to       *haplo
+}
+
+// Network holds the haplotypes and edges of a haplotype network
+type Network struct {
+	Method  string // msn (minimum spanning network) or mjn (median joining network)
+	nodes   []*haplo
+	edges   []*Hapedge
+	nodeset map[string]*haplo // nodes by bitstring
+}
+
+// hapdistance is the number of variants in one haplotype but not the other
+func hapdistance(a *haplo, b *haplo) int {
+	return new(bitsy.Set).SetXor(a.bitset, b.bitset).Size()
+}
+
+// setlist returns the members of a bitset in order
+func setlist(set *bitsy.Set) []int {
+	list := make([]int, 0, set.Size())
+	set.Visit(func(n int) bool {
+		list = append(list, n)
+		return false
+	})
+	return list
+}
+
+// newedge makes the edge between a and b, directed from the haplotype with fewer variants (then lower ID)
+func newedge(a *haplo, b *haplo) *Hapedge {
+	if b.bitset.Size() < a.bitset.Size() || (b.bitset.Size() == a.bitset.Size() && b.ID < a.ID) {
+		a, b = b, a
+	}
+	edge := &Hapedge{From: a.ID, To: b.ID, from: a, to: b}
+	edge.Distance = hapdistance(a, b)
+	edge.Weight = a.hapcount + b.hapcount
+	edge.Gained = setlist(new(bitsy.Set).SetAndNot(b.bitset, a.bitset))
+	edge.Lost = setlist(new(bitsy.Set).SetAndNot(a.bitset, b.bitset))
+	return edge
+}
+
+// Network builds a haplotype network over the haplotypes with counts of at least hapmin
+// method msn gives the minimum spanning network, the union of all minimum spanning trees
+// method mjn adds median haplotypes (status median) for each pair of edges meeting at a haplotype,
+// median joining style, until no new medians appear, then drops medians joining fewer than three
+func (haps *Haplotypes) Network(hapmin int, method string) *Network {
+	fmt.Println("I am about to build the haplotype network.", method, hapmin, len(haps.haplist))
+	net := new(Network)
+	net.Method = method
+	net.nodeset = make(map[string]*haplo)
+	nextID := 0
+	for _, hinfo := range haps.haplist {
+		if hinfo.ID >= nextID {
+			nextID = hinfo.ID + 1
+		}
+		if hinfo.hapcount >= hapmin && net.nodeset[hinfo.bitstring] == nil {
+			if hinfo.status == "" {
+				hinfo.status = "orig"
+			}
+			net.nodes = append(net.nodes, hinfo)
+			net.nodeset[hinfo.bitstring] = hinfo
+		}
+	}
+
+	switch method {
+	case "msn":
+		net.spanning()
+	case "mjn":
+		for added := true; added; {
+			net.spanning()
+			added = false
+			for _, median := range net.medians() {
+				if net.nodeset[median.String()] == nil {
+					mhap := new(haplo)
+					mhap.Init()
+					mhap.bitset = median
+					mhap.bitstring = median.String()
+					mhap.variants = setlist(median)
+					mhap.status = "median"
+					mhap.ID = nextID
+					nextID++
+					net.nodes = append(net.nodes, mhap)
+					net.nodeset[mhap.bitstring] = mhap
+					added = true
+				}
+			}
+		}
+		for net.prune() {
+			net.spanning()
+		}
+	default:
+		fmt.Println("Exiting, unknown network method", method, "expected msn or mjn")
+		os.Exit(1)
+	}
+	fmt.Println("network haplotypes and edges", len(net.nodes), len(net.edges))
+	return net
+}
+
+// spanning sets the edges to the minimum spanning network of the nodes
+// pairs are taken in distance classes, shortest first, and every pair in a class
+// joining haplotypes not yet connected by shorter edges is kept (Bandelt et al 1999)
+func (net *Network) spanning() {
+	type hapair struct{ a, b, distance int }
+	pairs := make([]hapair, 0)
+	for i := 0; i < len(net.nodes); i++ {
+		for j := i + 1; j < len(net.nodes); j++ {
+			pairs = append(pairs, hapair{i, j, hapdistance(net.nodes[i], net.nodes[j])})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].distance < pairs[j].distance })
+
+	component := make([]int, len(net.nodes)) // union-find over node indices
+	for i := range component {
+		component[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if component[i] != i {
+			component[i] = find(component[i])
+		}
+		return component[i]
+	}
+
+	net.edges = make([]*Hapedge, 0)
+	for start := 0; start < len(pairs); {
+		end := start
+		for end < len(pairs) && pairs[end].distance == pairs[start].distance {
+			end++
+		}
+		for _, pair := range pairs[start:end] { // connect against the components before this class
+			if find(pair.a) != find(pair.b) {
+				net.edges = append(net.edges, newedge(net.nodes[pair.a], net.nodes[pair.b]))
+			}
+		}
+		for _, pair := range pairs[start:end] {
+			component[find(pair.a)] = find(pair.b)
+		}
+		start = end
+	}
+}
+
+// neighbors returns the haplotypes joined to each haplotype
+func (net *Network) neighbors() map[*haplo][]*haplo {
+	links := make(map[*haplo][]*haplo)
+	for _, edge := range net.edges {
+		links[edge.from] = append(links[edge.from], edge.to)
+		links[edge.to] = append(links[edge.to], edge.from)
+	}
+	return links
+}
+
+// medians returns the majority haplotype of each haplotype and two of its neighbors
+func (net *Network) medians() []*bitsy.Set {
+	medians := make([]*bitsy.Set, 0)
+	neighbors := net.neighbors()
+	for _, hinfo := range net.nodes {
+		links := neighbors[hinfo]
+		for i := 0; i < len(links); i++ {
+			for j := i + 1; j < len(links); j++ {
+				median := new(bitsy.Set).SetAnd(hinfo.bitset, links[i].bitset)
+				median.Or(new(bitsy.Set).SetAnd(hinfo.bitset, links[j].bitset))
+				median.Or(new(bitsy.Set).SetAnd(links[i].bitset, links[j].bitset))
+				medians = append(medians, median)
+			}
+		}
+	}
+	return medians
+}
+
+// prune removes median haplotypes joined to fewer than three others, reporting whether any went
+func (net *Network) prune() bool {
+	links := net.neighbors()
+	kept := make([]*haplo, 0, len(net.nodes))
+	for _, hinfo := range net.nodes {
+		if hinfo.status == "median" && len(links[hinfo]) < 3 {
+			delete(net.nodeset, hinfo.bitstring)
+		} else {
+			kept = append(kept, hinfo)
+		}
+	}
+	pruned := len(kept) < len(net.nodes)
+	net.nodes = kept
+	return pruned
+}
+
+// Edges returns the edges of the network
+func (net *Network) Edges() []*Hapedge {
+	return net.edges
+}
+
+// sortedchildren returns the bitstrings of a child map in order, so output is stable
+func sortedchildren(children map[string]*haplo) []string {
+	keys := make([]string, 0, len(children))
+	for key := range children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// idlist joins variant IDs with commas, - if there are none
+func idlist(IDs []int) string {
+	if len(IDs) == 0 {
+		return "-"
+	}
+	words := make([]string, len(IDs))
+	for i, ID := range IDs {
+		words[i] = strconv.Itoa(ID)
+	}
+	return strings.Join(words, ",")
+}
+
+// writeedges writes edges as a tab separated edge list
+func writeedges(writer *bufio.Writer, edges []*Hapedge) {
+	fmt.Fprintln(writer, "from\tto\tfromhaplo\ttohaplo\tdistance\tweight\tgained\tlost")
+	for _, edge := range edges {
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\n", edge.From, edge.To, edge.from.bitstring, edge.to.bitstring,
+			edge.Distance, edge.Weight, idlist(edge.Gained), idlist(edge.Lost))
+	}
+}
+
+// EdgePrint outputs the network edge list
+func (net *Network) EdgePrint(edgefile string) {
+	fmt.Println("Opening Network Edge Output File", edgefile)
+	feout, err := os.Create(edgefile)
+	globals.Check(err)
+	defer feout.Close()
+	ewriter := bufio.NewWriter(feout)
+	defer ewriter.Flush() // need this to get output
+	writeedges(ewriter, net.edges)
+}
+
+// NodePrint outputs the network haplotypes, including any medians
+func (net *Network) NodePrint(nodefile string) {
+	fmt.Println("Opening Network Node Output File", nodefile)
+	fnout, err := os.Create(nodefile)
+	globals.Check(err)
+	defer fnout.Close()
+	nwriter := bufio.NewWriter(fnout)
+	defer nwriter.Flush() // need this to get output
+
+	fmt.Fprintln(nwriter, "ID\tcount\thaplo\tstatus\tvariants")
+	for _, hinfo := range net.nodes {
+		fmt.Fprintf(nwriter, "%d\t%d\t%s\t%s\t%s\n", hinfo.ID, hinfo.hapcount, hinfo.bitstring, hinfo.status, idlist(hinfo.variants))
+	}
+}
@@ -0,0 +1,156 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  recombinant haplotypes // //
+//
+
+// Recombinant explains a haplotype as the left part of one observed haplotype joined to
+// the right part of another, with the breakpoint between positions BreakFrom and BreakTo
+type Recombinant struct {
+	Child      int // haplotype IDs
+	Left       int
+	Right      int
+	Count      int // observed count of the child
+	BreakFrom  int // last variant position taken from the left parent
+	BreakTo    int // first variant position taken from the right parent
+	Extra      int // variants of the child explained by neither parent
+	Single     int // ID of the closest single haplotype
+	SingleDiff int // variants between the child and that haplotype
+	parents    int // total count of the two parents, to break ties
+	breakt     int // index in the placed variants of the last breakpoint in the interval
+}
+
+// Recombinants tests each haplotype with a count of at least hapmin for being a mosaic of two
+// more common haplotypes, split at a single breakpoint, carrying variants particular to each;
+// positions gives the reference position of each variant ID, and variants without one are ignored
+// a mosaic is reported when its extra mutations plus penalty, the cost of the recombination,
+// are fewer than the differences from the closest single haplotype
+func (haps *Haplotypes) Recombinants(positions map[int]int, hapmin int, penalty int) []*Recombinant {
+	fmt.Println("I am about to look for recombinant haplotypes.", hapmin, penalty, len(haps.haplist))
+	candidates := make([]*haplo, 0)
+	for _, hinfo := range haps.haplist {
+		if hinfo.hapcount >= hapmin {
+			candidates = append(candidates, hinfo)
+		}
+	}
+
+	// the placed variants of all candidates, in reference order
+	placed := make(map[int]bool)
+	unplaced := make(map[int]bool)
+	for _, hinfo := range candidates {
+		for _, vID := range hinfo.variants {
+			if _, ok := positions[vID]; ok {
+				placed[vID] = true
+			} else {
+				unplaced[vID] = true
+			}
+		}
+	}
+	order := make([]int, 0, len(placed))
+	for vID := range placed {
+		order = append(order, vID)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if positions[order[i]] != positions[order[j]] {
+			return positions[order[i]] < positions[order[j]]
+		}
+		return order[i] < order[j]
+	})
+	fmt.Println("variants placed, and without a position", len(order), len(unplaced))
+
+	recombinants := make([]*Recombinant, 0)
+	for _, child := range candidates {
+		// mismatch[p][t] counts the first t placed variants where child and parent p differ
+		parents := make([]*haplo, 0)
+		mismatch := make([][]int, 0)
+		single, singlediff := -1, len(order)+1
+		for _, parent := range candidates {
+			if parent == child || parent.hapcount < child.hapcount {
+				continue
+			}
+			prefix := make([]int, len(order)+1)
+			for t, vID := range order {
+				prefix[t+1] = prefix[t]
+				if child.bitset.Contains(vID) != parent.bitset.Contains(vID) {
+					prefix[t+1]++
+				}
+			}
+			if prefix[len(order)] < singlediff {
+				single, singlediff = parent.ID, prefix[len(order)]
+			}
+			parents = append(parents, parent)
+			mismatch = append(mismatch, prefix)
+		}
+		if single < 0 || singlediff <= penalty {
+			continue // nothing to compare, or a single parent explains it as well
+		}
+
+		var best *Recombinant
+		for a, left := range parents {
+			for b, right := range parents {
+				if a == b {
+					continue
+				}
+				// the child must carry variants only the left parent has before the breakpoint,
+				// and variants only the right parent has after it
+				fromleft, fromright := 0, 0
+				for _, vID := range order {
+					if child.bitset.Contains(vID) && right.bitset.Contains(vID) && !left.bitset.Contains(vID) {
+						fromright++
+					}
+				}
+				for t := 1; t < len(order); t++ {
+					vID := order[t-1]
+					if child.bitset.Contains(vID) && left.bitset.Contains(vID) && !right.bitset.Contains(vID) {
+						fromleft++
+					}
+					if child.bitset.Contains(vID) && right.bitset.Contains(vID) && !left.bitset.Contains(vID) {
+						fromright--
+					}
+					extra := mismatch[a][t] + mismatch[b][len(order)] - mismatch[b][t]
+					if fromleft == 0 || fromright == 0 || extra+penalty >= singlediff {
+						continue
+					}
+					if best == nil || extra < best.Extra || (extra == best.Extra && left.hapcount+right.hapcount > best.parents) {
+						best = &Recombinant{Child: child.ID, Left: left.ID, Right: right.ID, Count: child.hapcount,
+							BreakFrom: positions[order[t-1]], BreakTo: positions[order[t]], Extra: extra,
+							Single: single, SingleDiff: singlediff, parents: left.hapcount + right.hapcount, breakt: t}
+					} else if extra == best.Extra && best.Left == left.ID && best.Right == right.ID && best.breakt == t-1 {
+						best.BreakTo = positions[order[t]] // the breakpoint could be later still
+						best.breakt = t
+					}
+				}
+			}
+		}
+		if best != nil {
+			recombinants = append(recombinants, best)
+		}
+	}
+	fmt.Println("recombinant haplotypes found", len(recombinants))
+	return recombinants
+}
+
+// RecombinantPrint outputs recombinant haplotypes with their parents and breakpoint interval
+func RecombinantPrint(recfile string, recombinants []*Recombinant) {
+	fmt.Println("Opening Recombinant Output File", recfile)
+	frout, err := os.Create(recfile)
+	globals.Check(err)
+	defer frout.Close()
+	rwriter := bufio.NewWriter(frout)
+	defer rwriter.Flush() // need this to get output
+
+	fmt.Fprintln(rwriter, "ID\tcount\tleft\tright\tbreakfrom\tbreakto\textra\tsingle\tsinglediff")
+	for _, rec := range recombinants {
+		fmt.Fprintf(rwriter, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", rec.Child, rec.Count, rec.Left, rec.Right,
+			rec.BreakFrom, rec.BreakTo, rec.Extra, rec.Single, rec.SingleDiff)
+	}
+}
@@ -0,0 +1,31 @@
+package seqmer
+
+import "testing"
+
+func TestRecombinants(t *testing.T) {
+	positions := map[int]int{1: 100, 2: 200, 3: 300, 4: 400}
+
+	t.Run("breakpoint interval", func(t *testing.T) {
+		// the child takes 1 and 3 from the left parent and 2 and 4 from the right, so
+		// breaking after 1 or after 3 each leave one extra variant, breaking after 2 two;
+		// the interval stops at the first breakpoint, not across the worse one
+		haps := testhaps(100, []int{1, 3}, []int{2, 4}, []int{1, 2, 3, 4})
+		haps.haplist[2].hapcount = 50
+		found := haps.Recombinants(positions, 1, 0)
+		compare(len(found), 1, t)
+		rec := found[0]
+		compare([]int{rec.Child, rec.Left, rec.Right, rec.BreakFrom, rec.BreakTo, rec.Extra},
+			[]int{3, 1, 2, 100, 200, 1}, t)
+	})
+	t.Run("contiguous breakpoints", func(t *testing.T) {
+		// 1 and 2 from the left parent, 4 from the right, 3 shared: breaking after 2 or
+		// after 3 are as good, so the interval spans both
+		haps := testhaps(100, []int{1, 2, 3}, []int{3, 4}, []int{1, 2, 3, 4})
+		haps.haplist[2].hapcount = 50
+		found := haps.Recombinants(positions, 1, 0)
+		compare(len(found), 1, t)
+		rec := found[0]
+		compare([]int{rec.Child, rec.Left, rec.Right, rec.BreakFrom, rec.BreakTo, rec.Extra},
+			[]int{3, 1, 2, 200, 400, 0}, t)
+	})
+}
@@ -0,0 +1,272 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  haplotype network rendering // //
+//
+
+// svg page size and drawing constants
+const (
+	svgwidth    = 1000.0
+	svgheight   = 800.0
+	svgmargin   = 60.0
+	minradius   = 3.0  // radius of a haplotype never seen, eg a median
+	maxradius   = 24.0 // radius of the most common haplotype
+	forcerounds = 300  // force directed layout iterations
+)
+
+// palette colors nodes by metadata value, most common value first
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// point is a node position on the page
+type point struct {
+	x, y float64
+}
+
+// Render draws the network as an SVG, with layout force (force directed) or layered (by variant count)
+// node area scales with hapcount, edges are labelled with the variants gained (+) and lost (-), and
+// colorby (date, region, country or division; "" for none) colors nodes by their most common value
+func (net *Network) Render(svgfile string, layout string, colorby string) {
+	var places map[*haplo]point
+	switch layout {
+	case "force":
+		places = net.forcelayout()
+	case "layered":
+		places = net.layeredlayout()
+	default:
+		fmt.Println("Exiting, unknown network layout", layout, "expected force or layered")
+		os.Exit(1)
+	}
+	colors, legend := net.nodecolors(colorby)
+
+	fmt.Println("Opening Network SVG Output File", svgfile)
+	fsout, err := os.Create(svgfile)
+	globals.Check(err)
+	defer fsout.Close()
+	swriter := bufio.NewWriter(fsout)
+	defer swriter.Flush() // need this to get output
+
+	fmt.Fprintf(swriter, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%g\" height=\"%g\" viewBox=\"0 0 %g %g\" font-family=\"sans-serif\">\n",
+		svgwidth, svgheight, svgwidth, svgheight)
+	fmt.Fprintf(swriter, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	fmt.Fprintln(swriter, "<g stroke=\"gray\" stroke-width=\"1\">")
+	for _, edge := range net.edges {
+		from, to := places[edge.from], places[edge.to]
+		fmt.Fprintf(swriter, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\"/>\n", from.x, from.y, to.x, to.y)
+	}
+	fmt.Fprintln(swriter, "</g>")
+	fmt.Fprintln(swriter, "<g font-size=\"9\" fill=\"dimgray\" text-anchor=\"middle\">")
+	for _, edge := range net.edges {
+		from, to := places[edge.from], places[edge.to]
+		fmt.Fprintf(swriter, "<text x=\"%.1f\" y=\"%.1f\">%s</text>\n", (from.x+to.x)/2, (from.y+to.y)/2-2, xmlescape(edgelabel(edge)))
+	}
+	fmt.Fprintln(swriter, "</g>")
+
+	maxcount := 1
+	for _, hinfo := range net.nodes {
+		if hinfo.hapcount > maxcount {
+			maxcount = hinfo.hapcount
+		}
+	}
+	fmt.Fprintln(swriter, "<g stroke=\"black\" stroke-width=\"0.7\">")
+	for _, hinfo := range net.nodes {
+		place := places[hinfo]
+		radius := minradius + (maxradius-minradius)*math.Sqrt(float64(hinfo.hapcount)/float64(maxcount))
+		fmt.Fprintf(swriter, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"%.1f\" fill=\"%s\"><title>%s</title></circle>\n", place.x, place.y, radius, colors[hinfo],
+			xmlescape(fmt.Sprintf("%d %s count %d %s", hinfo.ID, hinfo.bitstring, hinfo.hapcount, hinfo.status)))
+		if radius > maxradius/2 {
+			fmt.Fprintf(swriter, "<text x=\"%.1f\" y=\"%.1f\" font-size=\"10\" stroke=\"none\" text-anchor=\"middle\">%d</text>\n", place.x, place.y+3, hinfo.ID)
+		}
+	}
+	fmt.Fprintln(swriter, "</g>")
+
+	if len(legend) > 0 {
+		fmt.Fprintf(swriter, "<g font-size=\"11\"><text x=\"10\" y=\"16\">%s</text>\n", xmlescape(colorby))
+		for i, value := range legend {
+			fmt.Fprintf(swriter, "<circle cx=\"16\" cy=\"%d\" r=\"5\" fill=\"%s\"/><text x=\"26\" y=\"%d\">%s</text>\n", 30+14*i, palette[i%len(palette)], 34+14*i, xmlescape(value))
+		}
+		fmt.Fprintln(swriter, "</g>")
+	}
+	fmt.Fprintln(swriter, "</svg>")
+}
+
+// edgelabel lists the variants gained and lost along an edge
+func edgelabel(edge *Hapedge) string {
+	words := make([]string, 0, len(edge.Gained)+len(edge.Lost))
+	for _, vID := range edge.Gained {
+		words = append(words, fmt.Sprintf("+%d", vID))
+	}
+	for _, vID := range edge.Lost {
+		words = append(words, fmt.Sprintf("-%d", vID))
+	}
+	return strings.Join(words, " ")
+}
+
+// nodecolors gives each node the palette color of its most common colorby value
+// values beyond the palette stay gray; returns the legend values in palette order
+func (net *Network) nodecolors(colorby string) (map[*haplo]string, []string) {
+	colors := make(map[*haplo]string)
+	for _, hinfo := range net.nodes {
+		colors[hinfo] = "lightgray"
+		if hinfo.status == "median" {
+			colors[hinfo] = "white"
+		}
+	}
+	if colorby == "" {
+		return colors, nil
+	}
+	field := Index(strings.Split(metaheader, "\t"), colorby)
+	if field < 0 {
+		fmt.Println("Exiting, unknown metadata field to color by", colorby, "expected one of", strings.Fields(metaheader))
+		os.Exit(1)
+	}
+
+	totals := make(map[string]int) // over the network, to order the legend
+	top := make(map[*haplo]string)
+	for _, hinfo := range net.nodes {
+		counts := make(map[string]int)
+		for key, count := range hinfo.meta {
+			value := strings.Split(key, "\t")[field]
+			if value == "NA" { // unknown stays gray
+				continue
+			}
+			counts[value] += count
+			totals[value] += count
+		}
+		for _, value := range sortedkeys(counts) {
+			if counts[value] > counts[top[hinfo]] {
+				top[hinfo] = value
+			}
+		}
+	}
+	legend := sortedkeys(totals)
+	sort.SliceStable(legend, func(i, j int) bool { return totals[legend[i]] > totals[legend[j]] })
+	if len(legend) > len(palette) {
+		legend = legend[:len(palette)]
+	}
+	for i, value := range legend {
+		for hinfo, nodevalue := range top {
+			if nodevalue == value {
+				colors[hinfo] = palette[i]
+			}
+		}
+	}
+	return colors, legend
+}
+
+// forcelayout places nodes by Fruchterman-Reingold force direction, starting from a circle so it is repeatable
+func (net *Network) forcelayout() map[*haplo]point {
+	places := make(map[*haplo]point)
+	n := len(net.nodes)
+	if n == 0 {
+		return places
+	}
+	width, height := svgwidth-2*svgmargin, svgheight-2*svgmargin
+	spacing := 0.5 * math.Sqrt(width*height/float64(n)) // ideal edge length
+	for i, hinfo := range net.nodes {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		places[hinfo] = point{width/2 + width/3*math.Cos(angle), height/2 + height/3*math.Sin(angle)}
+	}
+
+	temperature := width / 10
+	for round := 0; round < forcerounds; round++ {
+		moves := make(map[*haplo]point)
+		for _, a := range net.nodes { // all nodes repel
+			for _, b := range net.nodes {
+				if a != b {
+					dx, dy, dist := separation(places[a], places[b])
+					force := spacing * spacing / dist
+					moves[a] = point{moves[a].x + dx/dist*force, moves[a].y + dy/dist*force}
+				}
+			}
+		}
+		for _, edge := range net.edges { // joined nodes attract
+			dx, dy, dist := separation(places[edge.from], places[edge.to])
+			force := dist * dist / spacing
+			from, to := moves[edge.from], moves[edge.to]
+			moves[edge.from] = point{from.x - dx/dist*force, from.y - dy/dist*force}
+			moves[edge.to] = point{to.x + dx/dist*force, to.y + dy/dist*force}
+		}
+		for _, hinfo := range net.nodes { // and drift to the middle, so nothing piles up on the edges
+			place := places[hinfo]
+			moves[hinfo] = point{moves[hinfo].x + (width/2-place.x)/10, moves[hinfo].y + (height/2-place.y)/10}
+		}
+		for _, hinfo := range net.nodes {
+			move := moves[hinfo]
+			length := math.Max(math.Hypot(move.x, move.y), 0.01)
+			step := math.Min(length, temperature)
+			place := places[hinfo]
+			places[hinfo] = point{
+				math.Min(width, math.Max(0, place.x+move.x/length*step)),
+				math.Min(height, math.Max(0, place.y+move.y/length*step)),
+			}
+		}
+		temperature *= 0.98
+	}
+	for hinfo, place := range places {
+		places[hinfo] = point{place.x + svgmargin, place.y + svgmargin}
+	}
+	return places
+}
+
+// separation returns the offset of a from b and its length, never zero
+func separation(a point, b point) (float64, float64, float64) {
+	dx, dy := a.x-b.x, a.y-b.y
+	dist := math.Hypot(dx, dy)
+	if dist < 0.01 {
+		return 0.01, 0, 0.01
+	}
+	return dx, dy, dist
+}
+
+// layeredlayout places nodes in rows by variant count, ordering each row by the
+// mean position of the nodes it joins in the rows above (barycenter ordering)
+func (net *Network) layeredlayout() map[*haplo]point {
+	places := make(map[*haplo]point)
+	layers := make(map[int][]*haplo)
+	levels := make([]int, 0)
+	for _, hinfo := range net.nodes {
+		level := hinfo.bitset.Size()
+		if layers[level] == nil {
+			levels = append(levels, level)
+		}
+		layers[level] = append(layers[level], hinfo)
+	}
+	sort.Ints(levels)
+	neighbors := net.neighbors()
+
+	rowgap := (svgheight - 2*svgmargin) / math.Max(1, float64(len(levels)-1))
+	for row, level := range levels {
+		layer := layers[level]
+		colgap := (svgwidth - 2*svgmargin) / float64(len(layer)+1)
+		barycenter := make(map[*haplo]float64)
+		for i, hinfo := range layer {
+			sum, placed := 0.0, 0
+			for _, other := range neighbors[hinfo] {
+				if place, ok := places[other]; ok {
+					sum += place.x
+					placed++
+				}
+			}
+			barycenter[hinfo] = svgmargin + colgap*float64(i+1) // stay put when nothing above is joined
+			if placed > 0 {
+				barycenter[hinfo] = sum / float64(placed)
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool { return barycenter[layer[i]] < barycenter[layer[j]] })
+		for i, hinfo := range layer {
+			places[hinfo] = point{svgmargin + colgap*float64(i+1), svgmargin + rowgap*float64(row)}
+		}
+	}
+	return places
+}
@@ -2,8 +2,13 @@ package seqmer
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -26,8 +31,14 @@ type Sequences struct {
 	Name      string
 	seqfile   string
 	seqmap    map[string]string
-	seqfilter map[string]*filter
-	Filterdir string
+	seqfilter map[string]*filter // names (or accessions) listed in the filter file
+	Filterdir string             // include or exclude the names in seqfilter, none to ignore it
+	namepat   *regexp.Regexp     // names must match, if set
+	datefrom  string             // earliest collection date, if set
+	dateto    string             // latest collection date, if set
+	countries map[string]bool    // allowed countries, if any
+	dropped   map[string]int     // sequences filtered out, by reason
+	meta      *Metadata          // header patterns and metadata table, nil to just parse names
 	minlength int
 	record    bool
 	dofilter  bool
@@ -50,6 +61,8 @@ func (seqs *Sequences) Init(seqfile string, minlen int, llimit int, minline int,
 	seqs.seqmap = make(map[string]string)
 	seqs.seqfilter = make(map[string]*filter)
 	seqs.Filterdir = "none"
+	seqs.countries = make(map[string]bool)
+	seqs.dropped = make(map[string]int)
 
 	seqs.minlength = minlen
 	seqs.linelimit = llimit
@@ -97,6 +110,7 @@ func (seqs *Sequences) Print(printmode string, headers bool) {
 }
 
 // Print prints out sequences, parsing genbank name
+// printmode metadata prints the parsed and joined metadata for each sequence instead
 func (seqs *Sequences) Printparse(printmode string, headers bool) {
 	fmt.Println("Printing sequences to ", seqs.outfile)
 	fkout, _ := os.Create(seqs.outfile)
@@ -105,6 +119,13 @@ func (seqs *Sequences) Printparse(printmode string, headers bool) {
 	defer kwriter.Flush() // need this to get output
 
 	parsename := "shortparsename"
+	if printmode == "metadata" { // one line per sequence from the metadata model
+		fmt.Fprintln(kwriter, "name\taccession\tdate\tregion\tcountry\tdivision\tlocation\tlineage\tclade")
+		for name := range seqs.seqmap {
+			h := seqs.header(name)
+			fmt.Fprintf(kwriter, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", h.Name, h.Accession, h.Date, h.Region, h.Country, h.Division, h.Location, h.Lineage, h.Clade)
+		}
+	}
 	const firstsplitter = "|"
 	const splitter = "="
 	if printmode == parsename {
@@ -259,6 +280,7 @@ type variant struct {
 	varmer      string   // the composition of the variant
 	vartype     string   // eg snp, insert, del, compound
 	parent      *variant // if it is really an error on something else
+	meta        map[string]int // counts by time and place (metakey), if tracked
 } // will make global seqs
 
 // Init variant creates new if nil, then creates deviants string slice
@@ -294,18 +316,39 @@ func (vinfo *variant) Print(varcount int, minprint int, vwriter *bufio.Writer) {
 			}
 		}
 		if !hasNs {
-			hexstring := fmt.Sprintf("%x", vinfo.ID)
-			fmt.Fprintf(vwriter, "%d\t%d\t%d\t%s\t%s\t%d\t", vinfo.ID, vinfo.ID, varcount, vinfo.name, hexstring, deviant_count)
-			fmt.Fprintf(vwriter, "%s\t", vinfo.prev)
+			fmt.Fprintf(vwriter, "%d\t%d\t%d\t%s\t%s\t%d\t", vinfo.ID, vinfo.ID, varcount, vinfo.name, vinfo.vid(), deviant_count)
+			fmt.Fprintf(vwriter, "%s\t", anchor(vinfo.prev))
 			for dev := range vinfo.deviants {
 				fmt.Fprintf(vwriter, "%s\t", vinfo.deviants[dev])
 			}
-			fmt.Fprintf(vwriter, "%s\t", vinfo.next)
+			fmt.Fprintf(vwriter, "%s\t", anchor(vinfo.next))
 			fmt.Fprintf(vwriter, "\n")
 		}
 	}
 }
 
+// KmerVariantKey is the stable ID of a kmer variant, the same across runs and machines: the first 16 hex
+// digits of the sha256 of its anchor kmers and deviants, as prev|deviant,deviant,...|next
+func KmerVariantKey(prev string, deviants []string, next string) string {
+	sum := sha256.Sum256([]byte(prev + "|" + strings.Join(deviants, ",") + "|" + next))
+	return hex.EncodeToString(sum[:8])
+}
+
+// vid is the stable ID of the variant
+func (vinfo *variant) vid() string {
+	return KmerVariantKey(vinfo.prev, vinfo.deviants, vinfo.next)
+}
+
+// hasNs checks for Ns in the deviant kmers; such variants are not printed
+func (vinfo *variant) hasNs() bool {
+	for dev := range vinfo.deviants {
+		if strings.Contains(vinfo.deviants[dev], "N") {
+			return true
+		}
+	}
+	return false
+}
+
 // Sync syncs the tags from reference to focal variant
 func (v *variant) Sync(refvar *variant) {
 	v.next = refvar.next
@@ -350,8 +393,16 @@ type Variants struct {
 	minprint   int
 	Infile     string
 	Outfile    string
+	Metafile   string         // time and place breakdown output, tracked only if set
+	source     string         // metakey of the current sequence
+	sources    map[string]int // sequences by metakey, the denominators of the breakdown
 	free       bool
 	haps       *Haplotypes
+	store      *Store         // persistent store the variants are attached to, if any
+	rawcount   []int          // counts before Collapse folded error variants into their parents
+	npolicy    string         // variants with Ns: mask (dropped), split (at the N kmers) or keep
+	openended  bool           // keep variants missing an anchor, at sequence ends or split at Ns
+	dropped    map[string]int // occurrences of variants dropped, by reason
 } // will make global variants
 
 // Init creates new parameter structure of hash types
@@ -366,6 +417,8 @@ func (vars *Variants) Init(klen int, voutfile string, vminprint int) {
 	vars.total = 0
 	vars.klen = klen // not strictly necessary, but helpful to have on hand
 	vars.minprint = vminprint
+	vars.npolicy = "mask"
+	vars.dropped = make(map[string]int)
 	vars.Outfile = voutfile
 	vars.free = true
 }
@@ -394,7 +447,8 @@ func (vars *Variants) Print() {
 	var vinfo *variant
 	minprint := vars.minprint
 	fmt.Fprintln(vwriter, "Variant dataset", vars.name, "total variants", vars.total, "min to print", vars.minprint, "k", vars.klen)
-	fmt.Fprintln(vwriter, "VariantID\torigID\tcount\tname\thexID\tdevnum\tprev\tdeviants\tnext")
+	// VID is the stable ID; VariantID is the run's own, which origID maps back to the variant in the run
+	fmt.Fprintln(vwriter, "VariantID\torigID\tcount\tname\tVID\tdevnum\tprev\tdeviants\tnext")
 	hexcount := 0
 	fmt.Println("length of variant list", len(vars.varlist))
 	for i := 0; i < (len(vars.varlist)); i++ {
@@ -403,27 +457,73 @@ func (vars *Variants) Print() {
 		deviant_count := len(vinfo.deviants)
 
 		if varcount >= minprint {
-			hasNs := false
-			for dev := range vinfo.deviants {
-				if strings.Contains(vinfo.deviants[dev], "N") {
-					hasNs = true
-				}
-			}
-			if !hasNs {
+			if vars.printable(vinfo) {
 				hexcount++
-				hexstring := fmt.Sprintf("%x", hexcount)
-				fmt.Fprintf(vwriter, "%d\t%d\t%d\t%s\t%s\t%d\t", hexcount, vinfo.ID, varcount, vinfo.name, hexstring, deviant_count)
-				fmt.Fprintf(vwriter, "%s\t", vinfo.prev)
+				printID := vars.printID(hexcount, vinfo)
+				fmt.Fprintf(vwriter, "%d\t%d\t%d\t%s\t%s\t%d\t", printID, vinfo.ID, varcount, vinfo.name, vinfo.vid(), deviant_count)
+				fmt.Fprintf(vwriter, "%s\t", anchor(vinfo.prev))
 				for dev := range vinfo.deviants {
 					fmt.Fprintf(vwriter, "%s\t", vinfo.deviants[dev])
 				}
-				fmt.Fprintf(vwriter, "%s\t", vinfo.next)
+				fmt.Fprintf(vwriter, "%s\t", anchor(vinfo.next))
 				fmt.Fprintf(vwriter, "\n")
 			}
 		}
 	}
 }
 
+// printable tells whether Print writes a variant: not folded into a parent by Collapse,
+// whose counts include it, and without Ns unless npolicy is keep
+func (vars *Variants) printable(vinfo *variant) bool {
+	return vinfo.parent == nil && (vars.npolicy == "keep" || !vinfo.hasNs())
+}
+
+// anchor is a prev or next kmer as printed, - for the missing anchor of an open ended variant
+func anchor(kmer string) string {
+	if kmer == "" {
+		return "-"
+	}
+	return kmer
+}
+
+// SetPolicy sets how variants with Ns (mask, split or keep) and variants missing an anchor,
+// at the ends of sequences or split at Ns, are handled
+func (vars *Variants) SetPolicy(npolicy string, openended bool) {
+	if npolicy != "mask" && npolicy != "split" && npolicy != "keep" {
+		fmt.Println("Exiting, npolicy must be mask, split or keep, not", npolicy)
+		os.Exit(1)
+	}
+	vars.npolicy = npolicy
+	vars.openended = openended
+	fmt.Println("Variants with Ns", npolicy, "and open ended variants kept", openended)
+}
+
+// DropPrint outputs the occurrences of variants dropped, or split at Ns, by reason
+func (vars *Variants) DropPrint() {
+	dropfile := vars.Outfile + "Dropped.xls"
+	fmt.Println("Opening Variant Drop Output File", dropfile)
+	fdout, err := os.Create(dropfile)
+	globals.Check(err)
+	defer fdout.Close()
+	dwriter := bufio.NewWriter(fdout)
+	defer dwriter.Flush() // need this to get output
+
+	fmt.Fprintln(dwriter, "reason\tcount")
+	for _, reason := range sortedkeys(vars.dropped) {
+		fmt.Fprintf(dwriter, "%s\t%d\n", reason, vars.dropped[reason])
+		fmt.Println("variant occurrences", reason, vars.dropped[reason])
+	}
+}
+
+// printID is the VariantID Print gives a variant: its own ID when attached to a store, which keeps IDs
+// across batches, otherwise a count of the variants printed so far
+func (vars *Variants) printID(hexcount int, vinfo *variant) int {
+	if vars.store != nil {
+		return vinfo.ID
+	}
+	return hexcount
+}
+
 // Print outputs variant info using the variant match structure
 func (vars *Variants) MatchPrint() {
 	matchoutfile := vars.Outfile + "Match.xls"
@@ -436,7 +536,7 @@ func (vars *Variants) MatchPrint() {
 	var varcount int
 	var vinfo *variant
 	fmt.Fprintln(vwriter, "Variant dataset", vars.name, "total variants", vars.total, "min to print", vars.minprint, "k", vars.klen)
-	fmt.Fprintln(vwriter, "VariantID\tID2\tcount\tname\tID\tdevnum\tprev\tdeviants\tnext")
+	fmt.Fprintln(vwriter, "VariantID\torigID\tcount\tname\tVID\tdevnum\tprev\tdeviants\tnext")
 	fmt.Println("length of variant list", len(vars.varlist))
 	for p := range vars.matches {
 		for n := range vars.matches[p].key1 {
@@ -479,12 +579,12 @@ func (vars *Variants) Read(varfile string, mincount int) {
 			fmt.Println("location of prev ID count", prev, ID, count)
 		} else { // read in variant and add to currentvar
 			elements := len(tokens)
-			vars.addref(tokens[prev]) // things are being added to currentvar
+			vars.currentvar.prev = strings.TrimPrefix(tokens[prev], "-") // - for the missing anchor of an open ended variant
 			thiscount, _ := strconv.Atoi(tokens[count])
 			thisID, _ := strconv.Atoi(tokens[ID])
 			vars.currentvar.ID = thisID
 			enddeviants := elements
-			for i := prev + 1; i < elements-1; i++ { // this is a hack in case there are blank elements
+			for i := prev + 1; i < elements; i++ { // stop at the first blank element, eg the trailing tab Print writes
 				if tokens[i] == "" {
 					enddeviants = i
 					break
@@ -494,7 +594,8 @@ func (vars *Variants) Read(varfile string, mincount int) {
 				vars.addnonref(tokens[i]) // add all elements except last as deviants, to currentvar
 			}
 			pretotal := vars.total
-			vars.addref(tokens[enddeviants-1]) // add last element as "next"
+			vars.currentvar.next = strings.TrimPrefix(tokens[enddeviants-1], "-") // add last element as "next"
+			vars.closecurrent()
 			if vars.total > pretotal {
 				if linecount < 4300 {
 					fmt.Println("info A ", linecount, thiscount, thisID, elements, vars.total)
@@ -532,6 +633,7 @@ type haplo struct {
 	status        string            // orig, stub, flub
 	lineage_count int               // the number of haplotypes for which this is a subset
 	descend_count int               // the total hapcounts in those descendant lineages
+	meta          map[string]int    // counts by time and place (metakey), if tracked
 } //
 
 // Init creates new parameter structure of hash types
@@ -566,8 +668,20 @@ type Haplotypes struct {
 	minprint   int // we might have a separate minimum haplotype count to print
 	Outfile    string
 	Infile     string
+	Metafile   string         // time and place breakdown output, tracked only if set
+	source     string         // metakey of the current sequence
+	sources    map[string]int // sequences by metakey, the denominators of the breakdown
+	Memberfile string         // per-sequence haplotype membership output, recorded only if set
+	seqname    string         // name of the current sequence
+	members    []member
 } // will make global haplos
 
+// member records the haplotype a sequence closed into
+type member struct {
+	name string
+	hap  *haplo
+}
+
 // Init creates new parameter structure of hash types
 func (haps *Haplotypes) Init(klen int, houtfile string, hminprint int) {
 	fmt.Println("Running hinit ", houtfile)
@@ -615,38 +729,58 @@ func (haps *Haplotypes) Print(mode int) {
 	}
 }
 
-// EdgePrint outputs ancestral edge info
+// EdgePrint outputs the lattice edges, from each haplotype to its children, as an edge list
 func (haps *Haplotypes) EdgePrint(edgefile string) {
-	fmt.Println("Opening Haplotype  Output File", edgefile)
-	fhout, _ := os.Create(edgefile)
+	fmt.Println("Opening Haplotype Edge Output File", edgefile)
+	fhout, err := os.Create(edgefile)
+	globals.Check(err)
 	defer fhout.Close()
 	hwriter := bufio.NewWriter(fhout)
 	defer hwriter.Flush() // need this to get output
 
-	var hinfo *haplo
 	fmt.Println("I am about to print edges.", haps.hapcount, len(haps.haplist))
-	fmt.Fprintln(hwriter, "Haplotype dataset", haps.name, "total variants", haps.total, "min to print", haps.minprint, "k", haps.klen)
-	fmt.Fprintln(hwriter, "ID\tcount\tbits\tstatus\tchildcount\tdescendcount\tID\tchild\tposterior\tID\tancestor\tposterior")
-	for i := 0; i < len(haps.haplist); i++ {
-		hinfo = haps.haplist[i]
-		hcount := hinfo.hapcount
-		if hcount >= 0 { // temporary until hcount is sensible
-			fmt.Fprintf(hwriter, "%d\t%d\t%s\t%s\t", hinfo.ID, hcount, hinfo.bitstring, hinfo.status)
-			childcount := len(hinfo.children)
-			descendcount := len(hinfo.descends)
-			fmt.Fprintf(hwriter, "%d\t%d\t", childcount, descendcount)
-			for j := range hinfo.children {
-				posterior := 1.0 / float64(childcount)
-				fmt.Fprintf(hwriter, "%d\t%s\t%f\t", hinfo.children[j].ID, hinfo.children[j].bitstring, posterior)
+	edges := make([]*Hapedge, 0)
+	for _, hinfo := range haps.haplist {
+		for _, bitstr := range sortedchildren(hinfo.children) {
+			edges = append(edges, newedge(hinfo, hinfo.children[bitstr]))
+		}
+	}
+	writeedges(hwriter, edges)
+}
+
+// MemberPrint outputs the haplotype each sequence closed into, with its variant IDs
+// mode table gives one line per sequence, mode sparse one line per sequence and variant
+// (a sparse sequence by variant matrix); variant IDs are the IDs in the haplotype file
+func (haps *Haplotypes) MemberPrint(mode string) {
+	fmt.Println("Opening Haplotype Membership Output File", haps.Memberfile)
+	fmout, err := os.Create(haps.Memberfile)
+	globals.Check(err)
+	defer fmout.Close()
+	mwriter := bufio.NewWriter(fmout)
+	defer mwriter.Flush() // need this to get output
+
+	switch mode {
+	case "table":
+		fmt.Fprintln(mwriter, "sequence\thapID\tnvariants\tvariants")
+		for _, m := range haps.members {
+			vIDs := make([]string, len(m.hap.variants))
+			for i, vID := range m.hap.variants {
+				vIDs[i] = strconv.Itoa(vID)
 			}
-			fmt.Fprintf(hwriter, "X\t")
-			for j := range hinfo.descends {
-				posterior := 1.0 / float64(descendcount)
-				fmt.Fprintf(hwriter, "%d\t%s\t%f\t", hinfo.descends[j].ID, hinfo.descends[j].bitstring, posterior)
+			fmt.Fprintf(mwriter, "%s\t%d\t%d\t%s\n", m.name, m.hap.ID, len(vIDs), strings.Join(vIDs, ","))
+		}
+	case "sparse":
+		fmt.Fprintln(mwriter, "sequence\tvariantID")
+		for _, m := range haps.members {
+			for _, vID := range m.hap.variants {
+				fmt.Fprintf(mwriter, "%s\t%d\n", m.name, vID)
 			}
-			fmt.Fprintf(hwriter, "\n")
 		}
+	default:
+		fmt.Println("Exiting, unknown membership print mode", mode, "expected table or sparse")
+		os.Exit(1)
 	}
+	fmt.Println("sequences in membership output", len(haps.members))
 }
 
 // Read reads in haplotype info from standard file
@@ -706,6 +840,22 @@ func (haps *Haplotypes) Read(hapfile string) {
 	fmt.Println("\nleaving after reading lines", linecount, " total haps", haps.total)
 }
 
+// Hapsummary gives the ID, count and variant IDs of a haplotype to callers outside seqmer
+type Hapsummary struct {
+	ID       int
+	Count    int
+	Variants []int
+}
+
+// Summaries lists the haplotypes in order
+func (haps *Haplotypes) Summaries() []Hapsummary {
+	summaries := make([]Hapsummary, len(haps.haplist))
+	for i, hinfo := range haps.haplist {
+		summaries[i] = Hapsummary{hinfo.ID, hinfo.hapcount, append([]int{}, hinfo.variants...)}
+	}
+	return summaries
+}
+
 // addnew adds new haplotype
 func (haps *Haplotypes) addnew(bitset *bitsy.Set, bitstr string, status string) {
 	if haps == nil {
@@ -727,80 +877,124 @@ func (haps *Haplotypes) addnew(bitset *bitsy.Set, bitstr string, status string)
 	}
 }
 
-func (haps *Haplotypes) addhap(hapbits *bitsy.Set, bitstr string) {
-	if haps.hapset[bitstr] != nil {
-		oldhap := haps.hapset[bitstr]
-		if len(oldhap.variants) > 1 && oldhap.status != "orig" {
-			fmt.Println("existing haplotype found", oldhap.ID, oldhap.hapcount, oldhap.bitstring, bitstr, oldhap.status)
-		}
-	} else {
-		haps.addnew(hapbits, bitstr, "stub")
-	}
-}
-
-// AllCombos goes through all the variants j greater than input ultimate variant
-// first merging the previous bitset with jth variant, adding the haplotype created
-// then recursively calling itself with the combined bitset and j
-func (haps *Haplotypes) AllCombos(hinfo *haplo, varlist []int, parent *haplo, prevbitset *bitsy.Set, ultimate int) {
-	varlen := len(hinfo.variants)
-	for j := ultimate + 1; j < varlen; j++ {
-		//fmt.Println("input is ", prevbitset, ultimate)
-		// create combined bitset, make corresponding string, and add haplotype
-		combobit := new(bitsy.Set)
-		combobit.Set(prevbitset)
-		combobit.Add(hinfo.variants[j])
-		bitstr := combobit.String()
-		haps.addhap(combobit, bitstr)
-
-		// add it as a child to parental hinfo
-		child := haps.hapset[bitstr]
-		child.lineage_count += 1
-		hinfo.descends[bitstr] = child
-		parentstring := "nothing"
-		newvarlist := make([]int, 0)
-		newvarlist = append(varlist, hinfo.variants[j])
-		for variant := range newvarlist {
-			parentbit := new(bitsy.Set)
-			parentbit.Set(combobit)
-			parentbit.Delete(newvarlist[variant])
-			parentstring = parentbit.String()
-			haps.addhap(parentbit, parentstring)
-			parent = haps.hapset[parentstring]
-			parent.children[bitstr] = child
-		}
-		haps.AllCombos(hinfo, newvarlist, child, combobit, j)
-	}
-}
-
 func (hinfo *haplo) testprint(marker string, loc int) {
 	fmt.Fprint(os.Stdout, "marker\tstatus\tlineages\tdescends\t")
 	fmt.Fprintf(os.Stdout, "%s\t%d\t%s\t%d\t%d\t\n", marker, loc, hinfo.status, hinfo.lineage_count, hinfo.descend_count)
 }
 
-// Combos scans the original haplotypes, setting initial combo parameters
-// then calls the recursive AllCombos function to find all the variant sub combinations
-func (haps *Haplotypes) Combos(hapmincombo int) {
-	var hinfo *haplo
-	fmt.Println("I am about to analyze combinations.", haps.hapcount, len(haps.haplist))
-	for i := 0; i < len(haps.haplist); i++ {
-		hinfo = haps.haplist[i]
+// Lattice builds the containment (Hasse) lattice among the haplotypes with counts over hapmincombo
+// children are the haplotypes one step up the lattice (no observed haplotype in between), descends
+// all the haplotypes containing this one; with infer, the shared variants of pairs of haplotypes
+// are added as stub haplotypes, the intermediates their common ancestors would have had, keeping
+// the maxinfer (if over 0) shared by the most pairs; one observed at or under hapmincombo is
+// marked a stub too, so its count is not added to the lineages as an original's would be
+// lineage_count is the number of original haplotypes for which this is a subset (itself included),
+// descend_count the total hapcounts in those lineages
+// for n haplotypes and N lattice nodes (n plus the stubs), inferring compares the n^2 pairs and
+// linking makes N^2 subset tests, with up to N^2 descends; without a cap on stubs N is itself
+// of order n^2, so set maxinfer to bound work and memory on large haplotype sets
+func (haps *Haplotypes) Lattice(hapmincombo int, infer bool, maxinfer int) {
+	fmt.Println("I am about to build the haplotype lattice.", len(haps.haplist), hapmincombo, infer, maxinfer)
+	nodes := make([]*haplo, 0)
+	inlattice := make(map[string]bool)
+	nextID := 0
+	for _, hinfo := range haps.haplist {
 		hinfo.status = "orig"
-		hinfo.lineage_count = 1
-		hinfo.descend_count = haps.haplist[i].hapcount
+		hinfo.lineage_count = 0
+		hinfo.descend_count = 0
+		if hinfo.ID >= nextID {
+			nextID = hinfo.ID + 1
+		}
+		if hinfo.hapcount > hapmincombo {
+			nodes = append(nodes, hinfo)
+			inlattice[hinfo.bitstring] = true
+		}
 	}
-	numhaps := len(haps.haplist) // we add to haplist, but we only want to scroll base haplist
-	for i := 0; i < numhaps; i++ {
-		hinfo = haps.haplist[i]
-		hcount := hinfo.hapcount
-		if hcount > hapmincombo {
-			combobit := new(bitsy.Set)
-			bitstr := combobit.String()
-			child := haps.hapset[bitstr]
-			varlist := make([]int, 0)
-			haps.AllCombos(hinfo, varlist, child, combobit, -1)
+	numorig := len(nodes)
+	if infer {
+		type meet struct {
+			bitset *bitsy.Set
+			bitstr string
+			pairs  int // number of pairs of haplotypes sharing exactly these variants
+		}
+		meets := make(map[string]*meet)
+		for i := 0; i < numorig; i++ {
+			for j := i + 1; j < numorig; j++ {
+				shared := new(bitsy.Set).SetAnd(nodes[i].bitset, nodes[j].bitset)
+				bitstr := shared.String()
+				if inlattice[bitstr] {
+					continue
+				}
+				if meets[bitstr] == nil {
+					meets[bitstr] = &meet{shared, bitstr, 0}
+				}
+				meets[bitstr].pairs++
+			}
+		}
+		ranked := make([]*meet, 0, len(meets))
+		for _, m := range meets {
+			ranked = append(ranked, m)
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].pairs != ranked[j].pairs {
+				return ranked[i].pairs > ranked[j].pairs
+			}
+			return ranked[i].bitstr < ranked[j].bitstr
+		})
+		if maxinfer > 0 && len(ranked) > maxinfer {
+			fmt.Println("keeping inferred haplotypes shared by the most pairs", maxinfer, "of", len(ranked))
+			ranked = ranked[:maxinfer]
+		}
+		for _, m := range ranked {
+			if haps.hapset[m.bitstr] == nil { // never observed, so infer it
+				haps.addnew(m.bitset, m.bitstr, "stub")
+				stub := haps.hapset[m.bitstr]
+				stub.hapcount = 0
+				stub.ID = nextID
+				nextID++
+				stub.variants = setlist(m.bitset)
+			} else {
+				haps.hapset[m.bitstr].status = "stub" // observed, but too rarely to be an original
+			}
+			nodes = append(nodes, haps.hapset[m.bitstr])
+			inlattice[m.bitstr] = true
+		}
+	}
+
+	// smaller haplotypes first, so subsets of a haplotype always come before it
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].bitset.Size() < nodes[j].bitset.Size() })
+	for _, hinfo := range nodes {
+		if hinfo.status == "orig" {
+			hinfo.lineage_count = 1
+			hinfo.descend_count = hinfo.hapcount
 		}
 	}
-	fmt.Println("old and new humnaps", numhaps, len(haps.haplist))
+	for i, child := range nodes {
+		covers := make([]*haplo, 0)   // parents one step down the lattice
+		for j := i - 1; j >= 0; j-- { // largest subsets first
+			parent := nodes[j]
+			if parent.bitset.Size() == child.bitset.Size() || !parent.bitset.Subset(child.bitset) {
+				continue
+			}
+			parent.descends[child.bitstring] = child
+			if child.status == "orig" {
+				parent.lineage_count++
+				parent.descend_count += child.hapcount
+			}
+			iscover := true
+			for _, cover := range covers {
+				if parent.bitset.Subset(cover.bitset) {
+					iscover = false
+					break
+				}
+			}
+			if iscover {
+				covers = append(covers, parent)
+				parent.children[child.bitstring] = child
+			}
+		}
+	}
+	fmt.Println("original and lattice haplotypes", numorig, len(nodes))
 }
 
 //
@@ -839,6 +1033,9 @@ type Oligos struct {
 	Outfile    string
 	kfile      string
 	printNs    bool
+	dorevcomp  bool
+	canonical  bool
+	checksum   uint32 // crc32 of the source of the counts, kept in binary kcounts
 	remnant    string
 } // will make global kmers
 
@@ -860,6 +1057,26 @@ func (kmers *Oligos) Init(klen int, koutfile string, doNs bool, kminprint int) {
 	kmers.printNs = doNs
 }
 
+// SetRevcomp turns on orienting each query sequence to the reference strand
+// in VarFind and HapBuilder; sequences are then read whole before kmerizing
+func (kmers *Oligos) SetRevcomp(dorevcomp bool) {
+	kmers.dorevcomp = dorevcomp
+}
+
+// SetCanonical turns on canonical kmers, where each kmer is counted and looked up
+// as the lesser of itself and its reverse complement, so both strands are pooled
+func (kmers *Oligos) SetCanonical(canonical bool) {
+	kmers.canonical = canonical
+}
+
+// key returns the form of kmer used in kcount and kmap, canonical or as is
+func (kmers *Oligos) key(kmer string) string {
+	if kmers.canonical {
+		return canon(kmer)
+	}
+	return kmer
+}
+
 func (kmers *Oligos) Clearqmatches() {
 	kmers.qmatches = nil
 	kmers.qmatches = make(map[string]*QSeqMatches)
@@ -887,7 +1104,8 @@ func (kmers *Oligos) GetoutfileMulti(kcountpre string, basename string, director
 	kmers.Outfile = directory + kcountpre + strlen + "_" + basename + ".kcounts"
 }
 
-// Kprint outputs kmer counts
+// Kprint outputs kmer counts as kmer\tcount lines; canonical counts are flagged by
+// a "# canonical=true" line first, so plain counts keep the original format
 func (kmers *Oligos) Kprint() {
 	fmt.Println("Opening Kmer Count Output File", kmers.Outfile)
 	fkout, _ := os.Create(kmers.Outfile)
@@ -895,6 +1113,9 @@ func (kmers *Oligos) Kprint() {
 	kwriter := bufio.NewWriter(fkout)
 	defer kwriter.Flush() // need this to get output
 
+	if kmers.canonical {
+		fmt.Fprintln(kwriter, "# canonical=true")
+	}
 	fmt.Fprintln(kwriter, "kmer\tcount")
 	fmt.Println("size of kmers.kcount", len(kmers.kcount))
 	fmt.Println("minprint, printNs", kmers.minprint, kmers.printNs)
@@ -958,11 +1179,17 @@ func (kmers *Oligos) Kposprint(outfile string, kmin int, kmax int) {
 	}
 }
 
-// Readk inputs kmer counts
+// Readk inputs kmer counts, from tab separated or binary .kcounts files
+// a klen of 0 takes k from the file, otherwise it must match
 func (kmers *Oligos) Readk(kcountfile string) {
 	// reading stuff
 	kmers.kfile = kcountfile
 	fmt.Println("File to open for Readk() is ", kmers.kfile)
+	if IsKbinary(kmers.kfile) {
+		kmers.readkbinary(kmers.kfile)
+		fmt.Println("kmer count in Readk is ", len(kmers.kcount))
+		return
+	}
 	fpin, err := os.Open(kmers.kfile)
 	globals.Check(err)
 	defer fpin.Close()
@@ -972,26 +1199,51 @@ func (kmers *Oligos) Readk(kcountfile string) {
 	const splitter = "\t"
 	const bipart = 2
 	var linecount int
+	kmers.checksum = 0
 	for scanner.Scan() {
-		if linecount > 0 {
-			line := scanner.Text() // should not include eol
+		line := scanner.Text() // should not include eol
+		kmers.checksum = crc32.Update(kmers.checksum, crc32.IEEETable, []byte(line))
+		switch {
+		case strings.HasPrefix(line, "#"): // header flags, eg # canonical=true
+			flag := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if strings.HasPrefix(flag, "canonical=") {
+				canonical, _ := strconv.ParseBool(strings.TrimPrefix(flag, "canonical="))
+				if canonical != kmers.canonical {
+					fmt.Println("Readk using canonical =", canonical, "from", kmers.kfile)
+				}
+				kmers.canonical = canonical
+			} else if strings.HasPrefix(flag, "k=") {
+				klen, _ := strconv.Atoi(strings.TrimPrefix(flag, "k="))
+				kmers.checkklen(klen, kmers.kfile)
+			}
+		case strings.HasPrefix(line, "kmer"+splitter): // column names
+		default:
 			tokens := strings.Split(line, splitter)
-			kmer := tokens[0]
+			kmers.checkklen(len(tokens[0]), kmers.kfile) // older files don't record k
 			count, _ := strconv.Atoi(tokens[1])
-			kmers.kcount[kmer] = count
-			kmers.kmap[kmer] = new(oligo)
-			kinfo := kmers.kmap[kmer]
-			kinfo.name = kmer
-			kinfo.kcount = count
-			kinfo.revcomp = rc(kmer)
-			kmers.rmap[kinfo.revcomp] = kinfo
-			kinfo.poses = make([]int, 0) // imagining option to max pos at 10
+			kmers.addk(tokens[0], count)
 		}
 		linecount++
 	}
 	fmt.Println("line count in Readk is ", linecount)
 }
 
+// addk adds count to kmer as read from a kcounts file, pooling the strands
+// of a file without a canonical flag that is read as canonical
+func (kmers *Oligos) addk(kmer string, count int) {
+	kmer = kmers.key(kmer)
+	kmers.kcount[kmer] += count
+	if kmers.kmap[kmer] == nil {
+		kmers.kmap[kmer] = new(oligo)
+		kinfo := kmers.kmap[kmer]
+		kinfo.name = kmer
+		kinfo.revcomp = rc(kmer)
+		kmers.rmap[kinfo.revcomp] = kinfo
+		kinfo.poses = make([]int, 0) // imagining option to max pos at 10
+	}
+	kmers.kmap[kmer].kcount = kmers.kcount[kmer]
+}
+
 // ReadPrimers gets primer locations
 func (kmers *Oligos) ReadPrimers(primerfile string, direction string) {
 	fmt.Println("File to open for ReadPrimers() is ", primerfile)
@@ -1034,80 +1286,35 @@ func (kmers *Oligos) PrintPrimers(outfile string) {
 	}
 }
 
-// QueryNotRefRC puts qmer counts in qnotkmers if not in kmers
+// QueryNotRefRC is QueryNotRef matching qmers on either strand of kmers if dorevcomp,
+// by comparing in canonical mode
 func (qnotkmers *Oligos) QueryNotRefRC(kmers *Oligos, qmers *Oligos, kqmers *Oligos, dorevcomp bool) {
-	for qmer, _ := range qmers.kcount {
-		origimer := qmer
-		orcmer := rc(qmer)           // original but rc
-		qmer = strings.ToUpper(qmer) // counting lower case will only happen when it hits
-		rcmer := rc(qmer)
-		// kinfo :=
-		if _, ok := kmers.kcount[qmer]; ok { // match to kmers always UC
-			kqmers.kcount[qmer] += qmers.kcount[origimer]
-		} else if _, ok := kmers.kcount[rcmer]; ok && dorevcomp {
-			kqmers.kcount[qmer] += qmers.kcount[origimer]
-		} else {
-			if dorevcomp {
-				fmt.Println("neither primer nor revcomp found", origimer, orcmer)
-			} else {
-				// fmt.Println("Original primer not found (no revcomp)", origimer)
-			}
-		}
-	}
-	for qmer, _ := range qmers.kcount {
-		origimer := qmer
-		qmer = strings.ToUpper(qmer) // counting lower case will only happen when it hits
-		rcmer := rc(qmer)
-		if _, ok := kqmers.kcount[qmer]; !ok {
-			if dorevcomp {
-				if _, ok := kqmers.kcount[rcmer]; !ok {
-					qnotkmers.kcount[qmer] += qmers.kcount[origimer]
-				}
-			} else {
-				qnotkmers.kcount[qmer] += qmers.kcount[origimer]
-			}
-		}
+	if dorevcomp && !kmers.canonical {
+		kmers = kmers.Kcanonical()
 	}
+	qnotkmers.QueryNotRef(kmers, qmers, kqmers)
 }
 
-// QueryNotRef puts qmer counts in qnotkmers if not in kmers
-func (qnotkmers *Oligos) QueryNotRef(kmers *Oligos, qmers *Oligos, kqmers *Oligos, direction string) {
-	kqcount := 0
-	qnkcount := 0
-	for qmer, _ := range qmers.kcount {
-		origimer := qmer
-		if direction == "right" {
-			qmer = rc(qmer) // original but rc
-			fmt.Println("flipping directions", origimer, qmer, direction, kmers.kcount[origimer], kmers.kcount[qmer])
-		} else {
-			fmt.Println("not flipping", origimer, qmer, direction, kmers.kcount[origimer], kmers.kcount[qmer])
-		}
-		qmer = strings.ToUpper(qmer)         // counting lower case will only happen when it hits
-		if _, ok := kmers.kcount[qmer]; ok { // match to kmers always UC
-			kqmers.kcount[origimer] += qmers.kcount[origimer]
-			kqcount++
-		} else {
-			fmt.Println("putting in qnotk", origimer, qmer, direction, kmers.kcount[origimer], kmers.kcount[qmer])
-			qnotkmers.kcount[origimer] += qmers.kcount[origimer]
-			qnkcount++
-		}
+// QueryNotRef puts qmer counts in qnotkmers if not in kmers, and in kqmers if they are;
+// canonical kmers match qmers from either strand
+func (qnotkmers *Oligos) QueryNotRef(kmers *Oligos, qmers *Oligos, kqmers *Oligos) {
+	inref := func(qmer string) bool {
+		return kmers.Has(strings.ToUpper(qmer)) // counting lower case will only happen when it hits
 	}
-	fmt.Println("total each type", kqcount, qnkcount)
+	kqmers.kfilter(qmers, inref)
+	qnotkmers.kfilter(qmers, func(qmer string) bool { return !inref(qmer) })
+	fmt.Println("total each type", kqmers.Size(), qnotkmers.Size())
 }
 
 // QueryCounts adds all kmers with qmer substrings to kqmers
+// and the qmers found to qkmers, counting each time they are found
 func (kqmers *Oligos) QueryCounts(kmers *Oligos, qmers *Oligos, qkmers *Oligos) {
-	for kmer, kcount := range kmers.kcount {
-		for i := 0; i < (kmers.klen - qmers.klen + 1); i++ {
-			submer := kmer[i : i+qmers.klen]
-			if _, ok := qmers.kcount[submer]; ok {
-				kqmers.kcount[kmer] = kcount
-				qkmers.kcount[submer]++
-				if submer == "ACCTTACGAAAC" {
-					fmt.Println("testmer", kmer, submer)
-				}
-			}
-		}
+	kq, qk := Kcontains(kmers, qmers)
+	for kmer, kcount := range kq.kcount {
+		kqmers.kcount[kmer] = kcount
+	}
+	for qmer, qcount := range qk.kcount {
+		qkmers.kcount[qmer] += qcount
 	}
 }
 
@@ -1212,15 +1419,17 @@ func (seqs *Sequences) SeqQKprint(outfile string, kmers *Oligos, refmers *Oligos
 // tagvars and haploscan (which call VarFind and HaploBuilder, respectively)
 //
 
-// addref2 adds ref kmer prev or next according to whether prev already exists
-// if next then closes current variant
+// addref2 adds ref kmer prev or next according to whether there are deviants since prev
+// if next then closes current variant, and kmer is the prev of the next stretch;
+// deviants with no prev are at the start of a sequence
 func (vars *Variants) addref2(kmer string) {
 	vinfo := vars.currentvar
-	if vinfo.prev == "" || len(vinfo.deviants) == 0 {
+	if len(vinfo.deviants) == 0 {
 		vinfo.prev = kmer
 	} else {
 		vinfo.next = kmer
 		vars.closecurrent2()
+		vars.currentvar.prev = kmer
 	}
 }
 
@@ -1231,33 +1440,91 @@ func (vars *Variants) addnonref2(kmer string) {
 }
 
 // closecurrent2 closes out the current variant and either saves it to variant list or
-// adds it to the previously existing tag path (prev,next, and midmer)
+// adds it to the previously existing tag path (prev,next, and midmer); variants with Ns
+// or missing an anchor are dropped, split or kept according to npolicy and openended
 func (vars *Variants) closecurrent2() {
-	vinfo := vars.currentvar
-	oldinfo := vars.getVarMatch(vinfo) // if new info, returns vinfo or nil if not free to add
-	if vars.free {
-		if oldinfo == vinfo { // this is a pointer comparison, only true if vinfo is new
-			vars.AddNewVariant(vinfo)
-		} else { // sets count for this variant at 1; updated later by Read() if needed
-			vars.varcount[oldinfo.ID-1]++
+	for _, vinfo := range vars.policed(vars.currentvar) {
+		oldinfo := vars.getVarMatch(vinfo) // if new info, returns vinfo or nil if not free to add
+		if vars.free {
+			if oldinfo == vinfo { // this is a pointer comparison, only true if vinfo is new
+				vars.AddNewVariant(vinfo)
+			} else { // sets count for this variant at 1; updated later by Read() if needed
+				vars.varcount[oldinfo.ID-1]++
+			}
+			vars.tally(oldinfo)
 		}
 	}
 	vars.clearCurrent() // set currentvar to nil (hopefully garbage collect) and Init
 }
 
+// closeend2 closes the current variant at the end of a sequence, where a stretch of
+// deviants has no next
+func (vars *Variants) closeend2() {
+	if len(vars.currentvar.deviants) > 0 {
+		vars.closecurrent2()
+	}
+	vars.clearCurrent()
+}
+
+// policed splits the variant at Ns and drops the pieces with Ns or missing an anchor,
+// according to npolicy and openended, counting the drops by reason
+func (vars *Variants) policed(vinfo *variant) []*variant {
+	kept := make([]*variant, 0, 1)
+	for _, piece := range vars.splitNs(vinfo) {
+		if piece.prev == "" && piece.next == "" {
+			vars.dropped["no anchor"]++
+		} else if (piece.prev == "" || piece.next == "") && !vars.openended {
+			vars.dropped["open ended"]++
+		} else if vars.npolicy == "mask" && piece.hasNs() {
+			vars.dropped["Ns masked"]++
+		} else {
+			kept = append(kept, piece)
+		}
+	}
+	return kept
+}
+
+// splitNs splits a variant at its deviant kmers with Ns when npolicy is split, giving the
+// stretch after prev and the stretch before next, each missing its other anchor, and the
+// stretches between Ns, missing both; otherwise the variant is returned as is
+func (vars *Variants) splitNs(vinfo *variant) []*variant {
+	if vars.npolicy != "split" || !vinfo.hasNs() {
+		return []*variant{vinfo}
+	}
+	vars.dropped["Ns split"]++
+	pieces := make([]*variant, 0, 2)
+	piece := &variant{prev: vinfo.prev, deviants: make([]string, 0)}
+	for _, deviant := range vinfo.deviants {
+		if !strings.Contains(deviant, "N") {
+			piece.deviants = append(piece.deviants, deviant)
+			continue
+		}
+		if len(piece.deviants) > 0 {
+			pieces = append(pieces, piece)
+		}
+		piece = &variant{deviants: make([]string, 0)}
+	}
+	if len(piece.deviants) > 0 {
+		piece.next = vinfo.next
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
 //
 // end new copies to manage FindVar to avoid messing up other functions that rely on them
 //
 
-// addref adds ref kmer prev or next according to whether prev already exists
-// if next then closes current variant
+// addref adds ref kmer prev or next according to whether there are deviants since prev
+// if next then closes current variant, and kmer is the prev of the next stretch
 func (vars *Variants) addref(kmer string) {
 	vinfo := vars.currentvar
-	if vinfo.prev == "" || len(vinfo.deviants) == 0 {
+	if len(vinfo.deviants) == 0 {
 		vinfo.prev = kmer
 	} else {
 		vinfo.next = kmer
 		vars.closecurrent()
+		vars.currentvar.prev = kmer
 	}
 }
 
@@ -1325,11 +1592,18 @@ func (vars *Variants) Increment(vinfo *variant) {
 // closecurrent tries to find old info for currentvar
 // if !vars.free and oldinfo is found, adds the variant to haplotypes
 // 		and clears currentvar
+// when building haplotypes, the deviants are first split or dropped by npolicy
+// and openended, as VarFind did, so they match the variants it wrote
 func (vars *Variants) closecurrent() {
-	vinfo := vars.currentvar
-	oldinfo := vars.getVarMatch(vinfo)    // if new info, returns vinfo or nil if not free to add
-	if !vars.free && (oldinfo != vinfo) { // build haplotype if recognized variant
-		vars.AddVarToHaps(oldinfo) // only adds if not nil
+	pieces := []*variant{vars.currentvar}
+	if !vars.free && len(vars.currentvar.deviants) > 0 {
+		pieces = vars.policed(vars.currentvar)
+	}
+	for _, vinfo := range pieces {
+		oldinfo := vars.getVarMatch(vinfo)    // if new info, returns vinfo or nil if not free to add
+		if !vars.free && (oldinfo != vinfo) { // build haplotype if recognized variant
+			vars.AddVarToHaps(oldinfo) // only adds if not nil
+		}
 	}
 	vars.clearCurrent() // set currentvar to nil (hopefully garbage collect) and Init
 }
@@ -1371,6 +1645,7 @@ func (vars *Variants) AddVarToHaps(vinfo *variant) {
 	} else {
 		hinfo := vars.haps.currenthap
 		hinfo.variants = append(hinfo.variants, vinfo.ID)
+		vars.tally(vinfo)
 	}
 }
 
@@ -1392,6 +1667,10 @@ func (vars *Variants) closecurrenthap() {
 			haps.hapset[currbits].ID = len(haps.haplist) - 1 // Added Jan 30 2020 but how was this ever working?
 		}
 		haps.hapset[currbits].hapcount = haps.hapset[currbits].hapcount + 1
+		haps.tally(haps.hapset[currbits])
+		if haps.Memberfile != "" && haps.seqname != "" { // no sequence before the first header
+			haps.members = append(haps.members, member{haps.seqname, haps.hapset[currbits]})
+		}
 		haps.currenthap = new(haplo)
 		haps.currenthap.Init()
 		haps.total++
@@ -1403,16 +1682,11 @@ func (kmers *Oligos) Countnonref(seqs *Sequences, seq string, name string, refme
 	var kmer string
 	for i := 0; i < (len(seq) - kmers.klen + 1); i++ {
 		kmer = seq[i : i+kmers.klen]
-		if refmers.kcount[kmer] > 0 { // no filter in place on refmer; these are mostly 1
+		if refmers.kcount[refmers.key(kmer)] > 0 { // no filter in place on refmer; these are mostly 1
 			vars.addref(kmer)
 		} else {
 			vars.addnonref(kmer)
-			if kmers.kmap[kmer] == nil {
-				kmers.kmap[kmer] = new(oligo)
-				kmers.kmap[kmer].Init(kmer, kmers.kcount[kmer])
-			}
-			kmers.kcount[kmer]++
-			kmers.total++
+			kmers.record(kmer)
 		}
 	}
 
@@ -1428,7 +1702,7 @@ func (kmers *Oligos) Findnonref(seqs *Sequences, seq string, name string, refmer
 	var kmer string
 	for i := 0; i < (len(seq) - kmers.klen + 1); i++ {
 		kmer = seq[i : i+kmers.klen]
-		if refmers.kcount[kmer] > 0 { // no filter in place on refmer; these are mostly 1
+		if refmers.kcount[refmers.key(kmer)] > 0 { // no filter in place on refmer; these are mostly 1
 			vars.addref2(kmer) // either start or end tentative stretch
 		} else {
 			vars.addnonref2(kmer) // continue adding to tentative stretch
@@ -1438,6 +1712,29 @@ func (kmers *Oligos) Findnonref(seqs *Sequences, seq string, name string, refmer
 	kmers.addremnant(seq)
 }
 
+// Orient returns seq in reference orientation, reverse complemented (and flipped true)
+// if more of its kmers are found on the reverse strand (rmap) than the forward strand;
+// canonical kmers carry no strand, so seq is left as is
+func (refmers *Oligos) Orient(seq string) (string, bool) {
+	var forward, reverse int
+	if refmers.canonical {
+		return seq, false
+	}
+	for i := 0; i < (len(seq) - refmers.klen + 1); i++ {
+		kmer := seq[i : i+refmers.klen]
+		if refmers.kcount[kmer] > 0 {
+			forward++
+		}
+		if refmers.rmap[kmer] != nil {
+			reverse++
+		}
+	}
+	if reverse > forward {
+		return rc(seq), true
+	}
+	return seq, false
+}
+
 // addremnant adds remnant to kmers in case it needs to be pre-pendend to next sequence fragment
 func (kmers *Oligos) addremnant(seq string) {
 	nextpos := len(seq) - kmers.klen + 1
@@ -1446,6 +1743,7 @@ func (kmers *Oligos) addremnant(seq string) {
 
 // record adds kmer to the kmers record
 func (kmers *Oligos) record(kmer string) {
+	kmer = kmers.key(kmer)
 	if kmers.kmap[kmer] == nil {
 		kmers.kmap[kmer] = new(oligo)
 		kmers.kmap[kmer].Init(kmer, kmers.kcount[kmer])
@@ -1458,7 +1756,7 @@ func (kmers *Oligos) record(kmer string) {
 func (kmers *Oligos) Countref(seqs *Sequences, seq string, name string) {
 	var kmer string
 	for i := 0; i < (len(seq) - kmers.klen + 1); i++ {
-		kmer = seq[i : i+kmers.klen]
+		kmer = kmers.key(seq[i : i+kmers.klen])
 		if kmers.kmap[kmer] == nil {
 			kmers.kmap[kmer] = new(oligo)
 			kmers.kmap[kmer].Init(kmer, kmers.kcount[kmer])
@@ -1476,14 +1774,100 @@ func (kmers *Oligos) Countref(seqs *Sequences, seq string, name string) {
 // large database sequence readers and kmerizers //
 //
 
-func checkfilter(seqfilter map[string]*filter, name string) bool {
-	var passfilter bool
-	if seqfilter[name] != nil {
-		passfilter = true
-	} else {
-		passfilter = false
+// SetFilter sets up sequence name filtering, used when dofilter is set; "empty" leaves a criterion unset
+// namefile lists names or accessions (first column) to include or exclude, according to direction
+// pattern is a regular expression the whole name must match
+// datefrom and dateto bound the collection date (YYYY[-MM[-DD]]), partial dates sort as the start of their period
+// countries is a comma separated list of allowed countries
+func (seqs *Sequences) SetFilter(namefile string, direction string, pattern string, datefrom string, dateto string, countries string) {
+	if namefile != "empty" {
+		seqs.Filterdir = direction
+		seqs.readfilter(namefile)
+	}
+	if pattern != "empty" {
+		seqs.namepat = regexp.MustCompile(pattern)
+	}
+	if datefrom != "empty" {
+		seqs.datefrom = datefrom
+	}
+	if dateto != "empty" {
+		seqs.dateto = dateto
+	}
+	if countries != "empty" {
+		for _, country := range strings.Split(countries, ",") {
+			seqs.countries[strings.TrimSpace(country)] = true
+		}
+	}
+	fmt.Println("Sequence filter: list, direction, pattern, dates, countries", len(seqs.seqfilter), seqs.Filterdir, pattern, datefrom, dateto, countries)
+}
+
+// SetMetadata sets where sequence metadata comes from, for filtering and breakdowns
+func (seqs *Sequences) SetMetadata(meta *Metadata) {
+	seqs.meta = meta
+}
+
+// header returns the metadata for a sequence name
+func (seqs *Sequences) header(name string) Seqheader {
+	return seqs.meta.Lookup(name)
+}
+
+// readfilter reads the list of sequence names or accessions into seqfilter, one per line
+func (seqs *Sequences) readfilter(namefile string) {
+	fmt.Println("File to open for readfilter() is ", namefile)
+	fpin, err := os.Open(namefile)
+	globals.Check(err)
+	defer fpin.Close()
+	scanner := bufio.NewScanner(fpin)
+	for scanner.Scan() {
+		name := strings.TrimPrefix(strings.TrimSpace(strings.Split(scanner.Text(), "\t")[0]), seqs.entrystart)
+		if name != "" && !strings.HasPrefix(name, "#") {
+			seqs.seqfilter[name] = new(filter)
+			seqs.seqfilter[name].name = name
+		}
+	}
+}
+
+// checkfilter reports whether the name or its accession is in the filter list
+func checkfilter(seqfilter map[string]*filter, header Seqheader) bool {
+	return seqfilter[header.Name] != nil || seqfilter[header.Accession] != nil
+}
+
+// passfilter checks a sequence against all the filter criteria, counting why any fail
+func (seqs *Sequences) passfilter(header Seqheader) bool {
+	if !seqs.dofilter {
+		return true
+	}
+	reason := ""
+	switch {
+	case seqs.Filterdir == "include" && !checkfilter(seqs.seqfilter, header):
+		reason = "not in include list"
+	case seqs.Filterdir == "exclude" && checkfilter(seqs.seqfilter, header):
+		reason = "in exclude list"
+	case seqs.namepat != nil && !seqs.namepat.MatchString(header.Name):
+		reason = "name pattern"
+	case (seqs.datefrom != "" || seqs.dateto != "") && header.Date == "":
+		reason = "no date"
+	case seqs.datefrom != "" && header.Date < seqs.datefrom:
+		reason = "before datefrom"
+	case seqs.dateto != "" && header.Date > seqs.dateto:
+		reason = "after dateto"
+	case len(seqs.countries) > 0 && !seqs.countries[header.Country]:
+		reason = "country"
+	}
+	if reason != "" {
+		seqs.dropped[reason]++
+		return false
+	}
+	return true
+}
+
+// Filterprint prints how many sequences were filtered out and why
+func (seqs *Sequences) Filterprint() {
+	if seqs.dofilter {
+		for reason, count := range seqs.dropped {
+			fmt.Println("Sequences filtered out,", reason, count)
+		}
 	}
-	return passfilter
 }
 
 // Kmerize reads fasta or fastq file, turns into kmers and counts them
@@ -1506,25 +1890,23 @@ func (seqs *Sequences) Kmerize(kmers *Oligos) {
 	}
 	fmt.Println("In Kmerizer, dofilter ", seqs.dofilter)
 	fmt.Println("File type is ", seqs.filetype, "and entry limit is", entrylimit)
-	passfilter := false // flag to see if name is in filter list
+	passfilter := true // flag to see if name passes the filter, always true unless dofilter
 
 	// read, record, count kmers
 	for scanner.Scan() {
 		lcount += 1
 		line := scanner.Text()              // should not include eol
 		trimline := strings.TrimSpace(line) // trim off leading and lagging whitespace
-		nofilter := !seqs.dofilter
+		kmers.checksum = crc32.Update(kmers.checksum, crc32.IEEETable, []byte(line))
 		if strings.HasPrefix(line, seqs.entrystart) {
 			name = strings.TrimPrefix(trimline, seqs.entrystart)
 			count += 1
 			entrycount = 1
 			kmers.remnant = ""
 			//fmt.Println("New seq", name, "number", count)
-			if seqs.dofilter {
-				passfilter = checkfilter(seqs.seqfilter, name)
-			}
+			passfilter = seqs.passfilter(seqs.header(name))
 		} else {
-			if (nofilter || passfilter) && (lcount < seqs.linelimit) && (entrylimit < 1 || entrycount <= entrylimit) {
+			if passfilter && (lcount < seqs.linelimit) && (entrylimit < 1 || entrycount <= entrylimit) {
 				seq = kmers.remnant + trimline
 				entrycount++
 				if (lcount <= seqs.linelimit) && (lcount > seqs.linemin) {
@@ -1534,6 +1916,7 @@ func (seqs *Sequences) Kmerize(kmers *Oligos) {
 			}
 		}
 	}
+	seqs.Filterprint()
 	fmt.Println("Seqs and Lines counted\n", count, lcount)
 }
 
@@ -1557,7 +1940,23 @@ func (seqs *Sequences) VarFind(kmers *Oligos, refmers *Oligos, vars *Variants) {
 		entrylimit = 1
 	}
 	fmt.Println("File type is ", seqs.filetype, "and entry limit is", entrylimit)
-	fmt.Println("In VarFinder, not filtering based on sequence name")
+	fmt.Println("In VarFinder, dofilter ", seqs.dofilter)
+	passfilter := true // flag to see if name passes the filter, always true unless dofilter
+	fmt.Println("Orienting sequences to reference strand", kmers.dorevcomp)
+
+	// with dorevcomp the entry is read whole, so its strand can be found first
+	var entry strings.Builder
+	var flipped int
+	findentry := func() {
+		if entry.Len() > 0 {
+			seq, isrc := refmers.Orient(entry.String())
+			if isrc {
+				flipped++
+			}
+			kmers.Findnonref(seqs, seq, name, refmers, vars)
+			entry.Reset()
+		}
+	}
 
 	// read, record, count kmers
 	for scanner.Scan() {
@@ -1566,20 +1965,30 @@ func (seqs *Sequences) VarFind(kmers *Oligos, refmers *Oligos, vars *Variants) {
 			line := scanner.Text()              // should not include eol
 			trimline := strings.TrimSpace(line) // trim off leading and lagging whitespace
 			if strings.HasPrefix(line, seqs.entrystart) {
+				findentry() // empty unless dorevcomp
 				name = strings.TrimPrefix(trimline, seqs.entrystart)
 				count += 1
 				entrycount = 1
 				kmers.remnant = ""
-				vars.closecurrent() // if there was a current variant, close it off
+				vars.closeend2() // if there was a current variant, close it off
+				header := seqs.header(name)
+				passfilter = seqs.passfilter(header)
+				if passfilter {
+					vars.setsource(header) // variants are tallied by time and place
+				}
 				if (count % 1000) == 0 {
 					fmt.Println("Doing seq", name, "number", count)
 				}
 			} else {
-				if entrylimit < 1 || entrycount <= entrylimit {
+				if passfilter && (entrylimit < 1 || entrycount <= entrylimit) {
 					seq = kmers.remnant + trimline
 					entrycount++
 					if lcount > seqs.linemin {
-						kmers.Findnonref(seqs, seq, name, refmers, vars)
+						if kmers.dorevcomp {
+							entry.WriteString(trimline)
+						} else {
+							kmers.Findnonref(seqs, seq, name, refmers, vars)
+						}
 					}
 				}
 			}
@@ -1587,8 +1996,11 @@ func (seqs *Sequences) VarFind(kmers *Oligos, refmers *Oligos, vars *Variants) {
 			break
 		}
 	}
-	vars.closecurrent() // otherwise last variant left hanging
+	findentry()
+	vars.closeend2() // otherwise last variant left hanging
+	seqs.Filterprint()
 	fmt.Println("Seqs and Lines counted\n", count, lcount)
+	fmt.Println("Seqs reverse complemented to reference strand", flipped)
 }
 
 // HapBuilder reads fasta or fastq file, finds known non-ref variants
@@ -1609,7 +2021,23 @@ func (seqs *Sequences) HapBuilder(kmers *Oligos, refmers *Oligos, vars *Variants
 		entrylimit = 1
 	}
 	fmt.Println("File type is ", seqs.filetype, "and entry limit is", entrylimit)
-	fmt.Println("In HapBuilder, not filtering ")
+	fmt.Println("In HapBuilder, dofilter ", seqs.dofilter)
+	passfilter := true // flag to see if name passes the filter, always true unless dofilter
+	fmt.Println("Orienting sequences to reference strand", kmers.dorevcomp)
+
+	// with dorevcomp the entry is read whole, so its strand can be found first
+	var entry strings.Builder
+	var flipped int
+	countentry := func() {
+		if entry.Len() > 0 {
+			seq, isrc := refmers.Orient(entry.String())
+			if isrc {
+				flipped++
+			}
+			kmers.Countnonref(seqs, seq, name, refmers, vars)
+			entry.Reset()
+		}
+	}
 
 	// read, record, count kmers
 	for scanner.Scan() {
@@ -1618,21 +2046,33 @@ func (seqs *Sequences) HapBuilder(kmers *Oligos, refmers *Oligos, vars *Variants
 			line := scanner.Text()              // should not include eol
 			trimline := strings.TrimSpace(line) // trim off leading and lagging whitespace
 			if strings.HasPrefix(line, seqs.entrystart) {
+				countentry() // empty unless dorevcomp
+				vars.closecurrent() // if there was a current variant, close it off
+				if passfilter {     // filtered sequences don't add a haplotype
+					vars.closecurrenthap() // if there was a current haplotype, close it off
+				}
 				name = strings.TrimPrefix(trimline, seqs.entrystart)
+				header := seqs.header(name)
+				passfilter = seqs.passfilter(header)
+				if passfilter {
+					vars.setsource(header) // variants and haplotypes are tallied by time and place
+				}
 				count += 1
 				entrycount = 1
 				kmers.remnant = ""
-				vars.closecurrent()    // if there was a current variant, close it off
-				vars.closecurrenthap() // if there was a current haplotype, close it off
 				if (count % 1000) == 0 {
 					fmt.Println("Doing seq", name, "number", count)
 				}
 			} else {
-				if entrylimit < 1 || entrycount <= entrylimit {
+				if passfilter && (entrylimit < 1 || entrycount <= entrylimit) {
 					seq = kmers.remnant + trimline
 					entrycount++
 					if lcount > seqs.linemin {
-						kmers.Countnonref(seqs, seq, name, refmers, vars)
+						if kmers.dorevcomp {
+							entry.WriteString(trimline)
+						} else {
+							kmers.Countnonref(seqs, seq, name, refmers, vars)
+						}
 					}
 				}
 			}
@@ -1640,9 +2080,14 @@ func (seqs *Sequences) HapBuilder(kmers *Oligos, refmers *Oligos, vars *Variants
 			break
 		}
 	}
-	vars.closecurrent()    // otherwise last variant left hanging
-	vars.closecurrenthap() // otherwise last haplotype left hanging
+	countentry()
+	vars.closecurrent() // otherwise last variant left hanging
+	if passfilter {
+		vars.closecurrenthap() // otherwise last haplotype left hanging
+	}
+	seqs.Filterprint()
 	fmt.Println("Seqs and Lines counted\n", count, lcount)
+	fmt.Println("Seqs reverse complemented to reference strand", flipped)
 }
 
 //
@@ -1809,6 +2254,7 @@ func rc(kmer string) string {
 	rcbits := []byte(kmer)
 	klen := len(kmer)
 	for i := range kbits {
+		rcbits[klen-i-1] = kbits[i] // Ns and other ambiguity codes stay as is
 		for n := range nucs {
 			if nucs[n] == kbits[i] {
 				rcbits[klen-i-1] = cnucs[n]
@@ -1818,6 +2264,14 @@ func rc(kmer string) string {
 	return string(rcbits)
 }
 
+// canon returns the canonical kmer, the lesser of kmer and its reverse complement
+func canon(kmer string) string {
+	if rckmer := rc(kmer); rckmer < kmer {
+		return rckmer
+	}
+	return kmer
+}
+
 func counthits2(intslice []int) int {
 	var hits int
 	if intslice != nil {
@@ -0,0 +1,210 @@
+package seqmer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compare(result interface{}, correct interface{}, t *testing.T) {
+	t.Helper()
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+// refkmers makes the reference kmers of seq, as read back from a kcounts file
+func refkmers(seq string, klen int, canonical bool) *Oligos {
+	refmers := new(Oligos)
+	refmers.Init(klen, "", false, 0)
+	refmers.SetCanonical(canonical)
+	for i := 0; i < len(seq)-klen+1; i++ {
+		refmers.addk(seq[i:i+klen], 1)
+	}
+	return refmers
+}
+
+const testref = "ATCGATATGGCGCGCATTTAGATTCGATCGGGCA"
+
+func TestRc(t *testing.T) {
+	compare(rc("ATCGG"), "CCGAT", t)
+	compare(rc("ACNGT"), "ACNGT", t) // Ns stay as is
+	compare(canon("TTTAG"), "CTAAA", t)
+}
+
+func TestOrient(t *testing.T) {
+	refmers := refkmers(testref, 5, false)
+	query := testref[3:25]
+
+	t.Run("forward", func(t *testing.T) {
+		seq, flipped := refmers.Orient(query)
+		compare(seq, query, t)
+		compare(flipped, false, t)
+	})
+	t.Run("reverse", func(t *testing.T) {
+		seq, flipped := refmers.Orient(rc(query))
+		compare(seq, query, t)
+		compare(flipped, true, t)
+	})
+	t.Run("reverse with a variant", func(t *testing.T) {
+		variant := query[:10] + "a" + query[11:]
+		seq, flipped := refmers.Orient(rc(variant))
+		compare(seq, variant, t)
+		compare(flipped, true, t)
+	})
+	t.Run("canonical", func(t *testing.T) {
+		seq, flipped := refkmers(testref, 5, true).Orient(rc(query))
+		compare(seq, rc(query), t)
+		compare(flipped, false, t)
+	})
+}
+
+func TestQueryNotRef(t *testing.T) {
+	refmers := refkmers(testref, 5, false)
+	qmers := new(Oligos)
+	qmers.Init(5, "", false, 0)
+	qmers.addk("TCGAT", 2)     // forward strand
+	qmers.addk(rc("GGCGC"), 3) // reverse strand
+	qmers.addk("AAAAA", 1)     // neither
+
+	counts := func(dorevcomp bool) (map[string]int, map[string]int) {
+		kqmers, qnotkmers := newset(qmers), newset(qmers)
+		qnotkmers.QueryNotRefRC(refmers, qmers, kqmers, dorevcomp)
+		return kqmers.kcount, qnotkmers.kcount
+	}
+	inref, notref := counts(false)
+	compare(inref, map[string]int{"TCGAT": 2}, t)
+	compare(notref, map[string]int{"GCGCC": 3, "AAAAA": 1}, t)
+	inref, notref = counts(true)
+	compare(inref, map[string]int{"TCGAT": 2, "GCGCC": 3}, t)
+	compare(notref, map[string]int{"AAAAA": 1}, t)
+}
+
+func TestKcontains(t *testing.T) {
+	// TAAA is on the reverse strand of the reference only (TTTA forward), so is
+	// found only in canonical mode, in the 5 8mers holding TTTA
+	found := make([]int, 0)
+	for _, canonical := range []bool{false, true} {
+		kmers := refkmers(testref, 8, canonical)
+		qmers := new(Oligos)
+		qmers.Init(4, "", false, 0)
+		qmers.SetCanonical(canonical)
+		qmers.addk("TAAA", 1)
+		kq, qk := Kcontains(kmers, qmers)
+		compare(qk.Count("TAAA"), kq.Size(), t)
+		found = append(found, kq.Size())
+	}
+	compare(found, []int{0, 5}, t)
+}
+
+func TestPassfilter(t *testing.T) {
+	usa := "hCoV-19/USA/CA-1/2020|2020-03-05"
+	chn := "hCoV-19/China/WH-1/2019|2019-12-30"
+	nodate := "hCoV-19/USA/NY-2/2020"
+	filtered := func(dofilter bool, namefile string, direction string, pattern string,
+		datefrom string, dateto string, countries string) ([]bool, map[string]int) {
+		seqs := new(Sequences)
+		seqs.Init("empty", 0, 0, 0, false, "fasta", dofilter)
+		seqs.SetFilter(namefile, direction, pattern, datefrom, dateto, countries)
+		passed := make([]bool, 0)
+		for _, name := range []string{usa, chn, nodate} {
+			passed = append(passed, seqs.passfilter(seqs.header(name)))
+		}
+		return passed, seqs.dropped
+	}
+	namefile := filepath.Join(t.TempDir(), "names.txt")
+	err := ioutil.WriteFile(namefile, []byte("# accessions\n>hCoV-19/USA/CA-1/2020\nhCoV-19/China/WH-1/2019\textra\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("off", func(t *testing.T) {
+		passed, _ := filtered(false, namefile, "exclude", "China", "2021", "empty", "Peru")
+		compare(passed, []bool{true, true, true}, t)
+	})
+	t.Run("include", func(t *testing.T) {
+		passed, dropped := filtered(true, namefile, "include", "empty", "empty", "empty", "empty")
+		compare(passed, []bool{true, true, false}, t)
+		compare(dropped, map[string]int{"not in include list": 1}, t)
+	})
+	t.Run("exclude", func(t *testing.T) {
+		passed, dropped := filtered(true, namefile, "exclude", "empty", "empty", "empty", "empty")
+		compare(passed, []bool{false, false, true}, t)
+		compare(dropped, map[string]int{"in exclude list": 2}, t)
+	})
+	t.Run("regex", func(t *testing.T) {
+		passed, _ := filtered(true, "empty", "include", "/USA/", "empty", "empty", "empty")
+		compare(passed, []bool{true, false, true}, t)
+	})
+	t.Run("dates", func(t *testing.T) {
+		passed, dropped := filtered(true, "empty", "include", "empty", "2020-01-01", "2020-12-31", "empty")
+		compare(passed, []bool{true, false, false}, t)
+		compare(dropped, map[string]int{"before datefrom": 1, "no date": 1}, t)
+		passed, dropped = filtered(true, "empty", "include", "empty", "empty", "2020-01-01", "empty")
+		compare(passed, []bool{false, true, false}, t)
+		compare(dropped, map[string]int{"after dateto": 1, "no date": 1}, t)
+	})
+	t.Run("countries", func(t *testing.T) {
+		passed, _ := filtered(true, "empty", "include", "empty", "empty", "empty", "China, Peru")
+		compare(passed, []bool{false, true, false}, t)
+	})
+}
+
+func TestPoliced(t *testing.T) {
+	// a stretch of deviants broken by an N, as HapBuilder and VarFind see it
+	stretch := func() *variant {
+		return &variant{prev: "ATCGA", deviants: []string{"TCGAt", "CGAtN", "GAtNT", "AtNTA", "tNTAG", "NTAGC", "TAGCa"}, next: "AGCaT"}
+	}
+	policed := func(npolicy string, openended bool) ([]*variant, map[string]int) {
+		vars := new(Variants)
+		vars.Init(5, "", 0)
+		vars.SetPolicy(npolicy, openended)
+		return vars.policed(stretch()), vars.dropped
+	}
+
+	kept, dropped := policed("mask", false)
+	compare(len(kept), 0, t)
+	compare(dropped, map[string]int{"Ns masked": 1}, t)
+	kept, _ = policed("keep", false)
+	compare(len(kept), 1, t)
+	kept, dropped = policed("split", false)
+	compare(len(kept), 0, t)
+	compare(dropped, map[string]int{"Ns split": 1, "open ended": 2}, t)
+	kept, _ = policed("split", true)
+	compare(len(kept), 2, t)
+	compare(kept[0].deviants, []string{"TCGAt"}, t)
+	compare(kept[1].deviants, []string{"TAGCa"}, t)
+	compare(kept[1].next, "AGCaT", t)
+}
+
+func TestHapMetaPrint(t *testing.T) {
+	vars := new(Variants)
+	vars.Init(5, "", 0)
+	haps := new(Haplotypes)
+	haps.Init(5, "", 0)
+	haps.Metafile = filepath.Join(t.TempDir(), "hapmeta.xls")
+	vars.Addhaps(haps)
+
+	// as HapBuilder: a haplotype is closed at every header, the first one empty
+	for _, header := range []Seqheader{
+		{Name: "hCoV-19/USA/CA-1/2020", Date: "2020-03-05", Country: "USA"},
+		{Name: "hCoV-19/USA/NY-2/2020", Date: "2020-03-05", Country: "USA"},
+	} {
+		vars.closecurrenthap()
+		vars.setsource(header)
+	}
+	vars.closecurrenthap()
+	haps.MetaPrint()
+
+	out, err := ioutil.ReadFile(haps.Metafile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for _, line := range lines {
+		compare(len(strings.Split(line, "\t")), 6, t)
+	}
+	compare(lines[1:], []string{"all\t2020-03-05\tNA\tUSA\tNA\t2", "0\t2020-03-05\tNA\tUSA\tNA\t2"}, t)
+}
@@ -0,0 +1,293 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	globals "AnVir/globals"
+)
+
+//
+// //  persistent variant and haplotype store // //
+//
+
+// The store is an append-only tab separated file, so that batches of sequences can be added to
+// the same variants and haplotypes, keeping their IDs. After a header line giving k, each batch
+// appends one line per variant or haplotype it counted, then a line closing the batch:
+//	#  vardb=path of the variant store numbering the variants of the haplotypes, once bound
+//	V  ID  count  prev  deviants (comma separated)  next
+//	H  ID  count  variant IDs (comma separated, - for none)
+//	B  batch name  time  variant lines  haplotype lines
+// counts are those of the batch, summed on reading; lines after the last B, from a batch that
+// never finished, are ignored
+
+// storevar is a variant as kept in the store
+type storevar struct {
+	ID       int
+	count    int
+	prev     string
+	next     string
+	deviants []string
+}
+
+// storehap is a haplotype as kept in the store
+type storehap struct {
+	ID       int
+	count    int
+	variants []int
+}
+
+// Store holds the variants and haplotypes of all finished batches in the store file
+type Store struct {
+	File      string
+	klen      int
+	variants  map[int]*storevar
+	haplos    map[int]*storehap
+	batches   int
+	vardb     string    // the variant store the haplotype variant IDs come from, as recorded
+	bindvardb string    // the variant store bound for this batch, recorded on Append if not yet
+	vars      *Variants // attached, with their counts when attached
+	varstored []int
+	haps      *Haplotypes
+	hapstored []int
+}
+
+// Open reads the store file, or starts a new store of kmer length klen if the file doesn't exist yet
+func (store *Store) Open(storefile string, klen int) {
+	store.File = storefile
+	store.klen = klen
+	store.variants = make(map[int]*storevar)
+	store.haplos = make(map[int]*storehap)
+	fpin, err := os.Open(storefile)
+	if os.IsNotExist(err) {
+		fmt.Println("Starting a new variant store", storefile)
+		return
+	}
+	globals.Check(err)
+	defer fpin.Close()
+
+	const splitter = "\t"
+	pendingvars := make([]*storevar, 0) // the batch being read, kept once its B line is read
+	pendinghaps := make([]*storehap, 0)
+	scanner := bufio.NewScanner(fpin)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // long haplotypes
+	for linecount := 1; scanner.Scan(); linecount++ {
+		tokens := strings.Split(scanner.Text(), splitter)
+		switch tokens[0] {
+		case "#":
+			if len(tokens) > 1 && strings.HasPrefix(tokens[1], "k=") {
+				storek, _ := strconv.Atoi(strings.TrimPrefix(tokens[1], "k="))
+				if klen > 0 && storek != klen {
+					fmt.Println("Exiting, kmer length in store", storefile, "is", storek, "but klen is", klen)
+					os.Exit(1)
+				}
+				store.klen = storek
+			}
+			if len(tokens) > 1 && strings.HasPrefix(tokens[1], "vardb=") {
+				store.vardb = strings.TrimPrefix(tokens[1], "vardb=")
+			}
+		case "V":
+			ID, _ := strconv.Atoi(tokens[1])
+			count, _ := strconv.Atoi(tokens[2])
+			pendingvars = append(pendingvars, &storevar{ID, count, tokens[3], tokens[5], strings.Split(tokens[4], ",")})
+		case "H":
+			ID, _ := strconv.Atoi(tokens[1])
+			count, _ := strconv.Atoi(tokens[2])
+			shap := &storehap{ID: ID, count: count, variants: make([]int, 0)}
+			if tokens[3] != "-" {
+				for _, vID := range strings.Split(tokens[3], ",") {
+					thisvar, _ := strconv.Atoi(vID)
+					shap.variants = append(shap.variants, thisvar)
+				}
+			}
+			pendinghaps = append(pendinghaps, shap)
+		case "B":
+			for _, svar := range pendingvars {
+				if old := store.variants[svar.ID]; old != nil {
+					old.count += svar.count
+				} else {
+					store.variants[svar.ID] = svar
+				}
+			}
+			for _, shap := range pendinghaps {
+				if old := store.haplos[shap.ID]; old != nil {
+					old.count += shap.count
+				} else {
+					store.haplos[shap.ID] = shap
+				}
+			}
+			pendingvars, pendinghaps = pendingvars[:0], pendinghaps[:0]
+			store.batches++
+		default:
+			fmt.Println("Exiting, unknown line", linecount, "in variant store", storefile)
+			os.Exit(1)
+		}
+	}
+	globals.Check(scanner.Err())
+	if len(pendingvars)+len(pendinghaps) > 0 {
+		fmt.Println("ignoring an unfinished batch at the end of the store", len(pendingvars), len(pendinghaps))
+	}
+	fmt.Println("store batches, variants and haplotypes", store.batches, len(store.variants), len(store.haplos))
+}
+
+// storedIDs returns the keys of a store map in order, exiting unless they run first to last without gaps,
+// as new IDs are numbered on from the last
+func storedIDs(IDs []int, first int, kind string) []int {
+	sort.Ints(IDs)
+	for i, ID := range IDs {
+		if ID != first+i {
+			fmt.Println("Exiting, the store is missing", kind, first+i)
+			os.Exit(1)
+		}
+	}
+	return IDs
+}
+
+// AttachVariants loads the stored variants into vars, before any are found, so a variant
+// found again is matched by prev, next and midmer and keeps its ID, and new variants get
+// the IDs after them; Print then writes these IDs
+func (store *Store) AttachVariants(vars *Variants) {
+	if vars.total > 0 || !vars.free {
+		fmt.Println("Exiting, variants must be attached to a store before any are found or read")
+		os.Exit(1)
+	}
+	IDs := make([]int, 0, len(store.variants))
+	for ID := range store.variants {
+		IDs = append(IDs, ID)
+	}
+	for _, ID := range storedIDs(IDs, 1, "variant") {
+		svar := store.variants[ID]
+		vinfo := new(variant)
+		vinfo.Init()
+		vinfo.ID, vinfo.rank = svar.ID, svar.ID
+		vinfo.prev, vinfo.next = svar.prev, svar.next
+		vinfo.deviants = svar.deviants
+		if vars.getVarMatch(vinfo) != vinfo {
+			fmt.Println("Exiting, variant", ID, "in the store matches an earlier one")
+			os.Exit(1)
+		}
+		vars.varlist = append(vars.varlist, vinfo)
+		vars.varcount = append(vars.varcount, svar.count)
+	}
+	vars.total = len(vars.varlist)
+	vars.store = store
+	store.vars = vars
+	store.varstored = append([]int{}, vars.varcount...)
+	fmt.Println("variants attached from the store", vars.total)
+}
+
+// BindVariants ties a haplotype store to the variant store vardb, as haplotypes are sets of
+// variant IDs that only mean the same variants across batches when a variant store keeps them;
+// exits unless vardb is set, the haplotype store is not bound to another variant store, and
+// every variant of vars (read from varinfile) has the ID vardb gives it
+func (store *Store) BindVariants(vardb string, vars *Variants) {
+	if vardb == "empty" {
+		fmt.Println("Exiting, a haplotype store needs vardb, the variant store of the tagvars run that wrote varinfile")
+		os.Exit(1)
+	}
+	vardb, err := filepath.Abs(vardb)
+	globals.Check(err)
+	if store.vardb != "" && store.vardb != vardb {
+		fmt.Println("Exiting, haplotype store", store.File, "uses variant store", store.vardb, "not", vardb)
+		os.Exit(1)
+	}
+	vstore := new(Store)
+	vstore.Open(vardb, store.klen)
+	stored := make(map[string]int)
+	for ID, svar := range vstore.variants {
+		stored[KmerVariantKey(svar.prev, svar.deviants, svar.next)] = ID
+	}
+	for _, vinfo := range vars.varlist {
+		if ID, ok := stored[vinfo.vid()]; !ok || ID != vinfo.ID {
+			fmt.Println("Exiting, variant", vinfo.ID, "of", vars.Infile, "is not numbered as in variant store", vardb)
+			os.Exit(1)
+		}
+	}
+	store.bindvardb = vardb
+	fmt.Println("haplotype store bound to variant store", vardb, len(vars.varlist))
+}
+
+// AttachHaplotypes loads the stored haplotypes into haps, before any are built, so a haplotype
+// built again keeps its ID and count, and new haplotypes get the IDs after them
+func (store *Store) AttachHaplotypes(haps *Haplotypes) {
+	if len(haps.haplist) > 0 {
+		fmt.Println("Exiting, haplotypes must be attached to a store before any are built or read")
+		os.Exit(1)
+	}
+	IDs := make([]int, 0, len(store.haplos))
+	for ID := range store.haplos {
+		IDs = append(IDs, ID)
+	}
+	for _, ID := range storedIDs(IDs, 0, "haplotype") {
+		shap := store.haplos[ID]
+		hinfo := new(haplo)
+		hinfo.Init()
+		hinfo.ID = shap.ID
+		hinfo.hapcount = shap.count
+		hinfo.variants = shap.variants
+		hinfo.varsToBits()
+		hinfo.bitstring = hinfo.bitset.String()
+		haps.haplist = append(haps.haplist, hinfo)
+		haps.hapset[hinfo.bitstring] = hinfo
+		store.hapstored = append(store.hapstored, shap.count)
+	}
+	store.haps = haps
+	fmt.Println("haplotypes attached from the store", len(haps.haplist))
+}
+
+// Append adds the counts of this batch for the attached variants and haplotypes to the store
+func (store *Store) Append(batch string) {
+	fmt.Println("Appending batch", batch, "to variant store", store.File)
+	if store.haps != nil && store.bindvardb == "" {
+		fmt.Println("Exiting, haplotypes are only appended to a store bound to a variant store")
+		os.Exit(1)
+	}
+	_, err := os.Stat(store.File)
+	isnew := os.IsNotExist(err)
+	fsout, err := os.OpenFile(store.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	globals.Check(err)
+	defer fsout.Close()
+	swriter := bufio.NewWriter(fsout)
+	defer swriter.Flush() // need this to get output
+
+	if isnew {
+		fmt.Fprintf(swriter, "#\tk=%d\tAnVir variant and haplotype store\n", store.klen)
+	}
+	if store.bindvardb != "" && store.vardb == "" {
+		fmt.Fprintf(swriter, "#\tvardb=%s\n", store.bindvardb)
+		store.vardb = store.bindvardb
+	}
+	var varlines, haplines int
+	if vars := store.vars; vars != nil {
+		for i, vinfo := range vars.varlist {
+			count := vars.varcount[i]
+			if i < len(store.varstored) {
+				count -= store.varstored[i]
+			}
+			if count > 0 || i >= len(store.varstored) {
+				fmt.Fprintf(swriter, "V\t%d\t%d\t%s\t%s\t%s\n", vinfo.ID, count, vinfo.prev, strings.Join(vinfo.deviants, ","), vinfo.next)
+				varlines++
+			}
+		}
+	}
+	if haps := store.haps; haps != nil {
+		for i, hinfo := range haps.haplist {
+			count := hinfo.hapcount
+			if i < len(store.hapstored) {
+				count -= store.hapstored[i]
+			}
+			if count > 0 || i >= len(store.hapstored) {
+				fmt.Fprintf(swriter, "H\t%d\t%d\t%s\n", hinfo.ID, count, idlist(hinfo.variants))
+				haplines++
+			}
+		}
+	}
+	fmt.Fprintf(swriter, "B\t%s\t%s\t%d\t%d\n", batch, time.Now().Format(time.RFC3339), varlines, haplines)
+	fmt.Println("variant and haplotype lines appended", varlines, haplines)
+}
@@ -0,0 +1,116 @@
+package seqmer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// findvar finds a variant as VarFind would, from its reference anchors and deviants
+func findvar(vars *Variants, prev string, deviants []string, next string) {
+	vars.addref2(prev)
+	for _, kmer := range deviants {
+		vars.addnonref2(kmer)
+	}
+	vars.addref2(next)
+	vars.clearCurrent()
+}
+
+// storedcounts returns the summed counts of the stored variants or haplotypes, by ID
+func storedcounts(store *Store) (map[int]int, map[int]int) {
+	varcounts, hapcounts := make(map[int]int), make(map[int]int)
+	for ID, svar := range store.variants {
+		varcounts[ID] = svar.count
+	}
+	for ID, shap := range store.haplos {
+		hapcounts[ID] = shap.count
+	}
+	return varcounts, hapcounts
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	vardb := filepath.Join(dir, "variants.db")
+	hapdb := filepath.Join(dir, "haplotypes.db")
+	snp := []string{"TCGAt", "CGAtA", "GAtAT", "AtATG", "tATGG"}
+	ins := []string{"GGCGa", "GCGaC", "CGaCG", "GaCGC", "aCGCA"}
+
+	batch := func(found ...int) *Variants {
+		store := new(Store)
+		store.Open(vardb, 5)
+		vars := new(Variants)
+		vars.Init(5, filepath.Join(dir, "variants.xls"), 0)
+		store.AttachVariants(vars)
+		for _, which := range found {
+			if which == 1 {
+				findvar(vars, "ATCGA", snp, "ATGGC")
+			} else {
+				findvar(vars, "TGGCG", ins, "CGCAT")
+			}
+		}
+		store.Append("batch")
+		return vars
+	}
+
+	t.Run("variants", func(t *testing.T) {
+		batch(1, 1)
+		vars := batch(2, 1)
+		compare(vars.varlist[0].ID, 1, t) // found again, keeps its ID
+		compare(vars.varlist[1].ID, 2, t) // new, numbered on
+		store := new(Store)
+		store.Open(vardb, 5)
+		varcounts, _ := storedcounts(store)
+		compare(store.batches, 2, t)
+		compare(varcounts, map[int]int{1: 3, 2: 1}, t)
+	})
+	t.Run("unfinished batch", func(t *testing.T) {
+		data, err := ioutil.ReadFile(vardb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unfinished := filepath.Join(dir, "unfinished.db")
+		err = ioutil.WriteFile(unfinished, append(data, []byte("V\t1\t10\tATCGA\t"+strings.Join(snp, ",")+"\tATGGC\n")...), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := new(Store)
+		store.Open(unfinished, 5)
+		varcounts, _ := storedcounts(store)
+		compare(varcounts, map[int]int{1: 3, 2: 1}, t)
+	})
+	t.Run("haplotypes", func(t *testing.T) {
+		// the variants table of the last batch, as haploscan reads it
+		vars := batch()
+		vars.Print()
+		hapbatch := func(varsets ...[]int) {
+			read := new(Variants)
+			read.Init(5, "", 0)
+			read.Read(vars.Outfile, 0)
+			store := new(Store)
+			store.Open(hapdb, 5)
+			store.BindVariants(vardb, read)
+			haps := new(Haplotypes)
+			haps.Init(5, "", 0)
+			store.AttachHaplotypes(haps)
+			read.Addhaps(haps)
+			for _, variants := range varsets {
+				haps.currenthap.variants = variants
+				read.closecurrenthap()
+			}
+			store.Append("batch")
+		}
+		hapbatch([]int{1}, []int{1, 2})
+		hapbatch([]int{1, 2}, []int{2})
+
+		store := new(Store)
+		store.Open(hapdb, 5)
+		_, hapcounts := storedcounts(store)
+		compare(hapcounts, map[int]int{0: 1, 1: 2, 2: 1}, t)
+		compare(store.haplos[2].variants, []int{2}, t)
+		abs, _ := filepath.Abs(vardb)
+		compare(store.vardb, abs, t)
+		data, _ := ioutil.ReadFile(hapdb)
+		compare(strings.Count(string(data), "vardb="), 1, t)
+	})
+}
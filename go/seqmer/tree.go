@@ -0,0 +1,314 @@
+package seqmer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	globals "AnVir/globals"
+	bitsy "github.com/yourbasic/bit"
+)
+
+//
+// //  haplotype trees // //
+//
+
+// treenode is a node of a rooted haplotype tree; leaves hold a haplotype
+type treenode struct {
+	hap      *haplo
+	children []*treenode
+	length   float64    // branch length to the parent
+	can0     *bitsy.Set // Fitch state sets: variants that may be absent, and present
+	can1     *bitsy.Set
+	state    *bitsy.Set // variants present after the parsimony pass
+	gained   []int      // variants gained and lost on the branch from the parent
+	lost     []int
+}
+
+// Haptree is a rooted tree of haplotypes
+type Haptree struct {
+	root      *treenode
+	leaves    []*treenode
+	parsimony bool
+	Score     int // parsimony changes over the tree, once mapped
+}
+
+// njnode is a node of the unrooted neighbor joining tree
+type njnode struct {
+	hap   *haplo
+	links []njlink
+}
+
+// njlink is a branch of the unrooted tree
+type njlink struct {
+	to     *njnode
+	length float64
+}
+
+// link joins two unrooted nodes
+func (a *njnode) link(b *njnode, length float64) {
+	a.links = append(a.links, njlink{b, length})
+	b.links = append(b.links, njlink{a, length})
+}
+
+// Tree builds a neighbor joining tree over the haplotypes with counts of at least hapmin,
+// using the Hamming distance between their variant sets, rooted on the branch to the
+// haplotype with fewest variants (the reference haplotype {} when present)
+func (haps *Haplotypes) Tree(hapmin int) *Haptree {
+	fmt.Println("I am about to build the haplotype tree.", hapmin, len(haps.haplist))
+	clusters := make([]*njnode, 0)
+	seen := make(map[string]bool)
+	for _, hinfo := range haps.haplist {
+		if hinfo.hapcount >= hapmin && !seen[hinfo.bitstring] {
+			clusters = append(clusters, &njnode{hap: hinfo})
+			seen[hinfo.bitstring] = true
+		}
+	}
+	tree := new(Haptree)
+	if len(clusters) == 0 {
+		fmt.Println("Exiting, no haplotypes with counts of at least", hapmin, "for a tree")
+		os.Exit(1)
+	}
+	outgroup := clusters[0]
+	for _, cluster := range clusters {
+		if cluster.hap.bitset.Size() < outgroup.hap.bitset.Size() {
+			outgroup = cluster
+		}
+	}
+
+	n := len(clusters)
+	distance := make([][]float64, n)
+	for i := range distance {
+		distance[i] = make([]float64, n)
+		for j := range distance[i] {
+			distance[i][j] = float64(hapdistance(clusters[i].hap, clusters[j].hap))
+		}
+	}
+	for n > 2 {
+		netdivergence := make([]float64, n)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				netdivergence[i] += distance[i][j]
+			}
+		}
+		besti, bestj := 0, 1
+		best := 0.0
+		for i := 0; i < n; i++ { // minimize the Q criterion
+			for j := i + 1; j < n; j++ {
+				q := float64(n-2)*distance[i][j] - netdivergence[i] - netdivergence[j]
+				if (i == 0 && j == 1) || q < best {
+					besti, bestj, best = i, j, q
+				}
+			}
+		}
+		lengthi := distance[besti][bestj]/2 + (netdivergence[besti]-netdivergence[bestj])/float64(2*(n-2))
+		lengthj := distance[besti][bestj] - lengthi
+		joined := new(njnode)
+		joined.link(clusters[besti], nonnegative(lengthi))
+		joined.link(clusters[bestj], nonnegative(lengthj))
+
+		// the joined node replaces i, and the last cluster moves into j
+		joindistance := distance[besti][bestj]
+		for k := 0; k < n; k++ {
+			distance[besti][k] = (distance[besti][k] + distance[bestj][k] - joindistance) / 2
+			distance[k][besti] = distance[besti][k]
+		}
+		distance[besti][besti] = 0
+		clusters[besti] = joined
+		last := n - 1
+		for k := 0; k < n; k++ {
+			distance[bestj][k] = distance[last][k]
+			distance[k][bestj] = distance[k][last]
+		}
+		distance[bestj][bestj] = 0
+		clusters[bestj] = clusters[last]
+		n--
+	}
+	if n == 2 {
+		clusters[0].link(clusters[1], distance[0][1])
+	}
+
+	// root on the outgroup's branch
+	tree.root = new(treenode)
+	if len(outgroup.links) == 0 {
+		tree.root = tree.rooted(outgroup, nil, 0)
+	} else {
+		link := outgroup.links[0]
+		tree.root.children = []*treenode{
+			tree.rooted(outgroup, link.to, link.length/2),
+			tree.rooted(link.to, outgroup, link.length/2),
+		}
+	}
+	fmt.Println("haplotypes in tree", len(tree.leaves))
+	return tree
+}
+
+// nonnegative clamps the occasional negative neighbor joining branch length
+func nonnegative(length float64) float64 {
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// rooted converts the unrooted subtree at node, away from from, into treenodes
+func (tree *Haptree) rooted(node *njnode, from *njnode, length float64) *treenode {
+	tnode := &treenode{hap: node.hap, length: length}
+	if node.hap != nil {
+		tree.leaves = append(tree.leaves, tnode)
+	}
+	for _, link := range node.links {
+		if link.to != from {
+			tnode.children = append(tnode.children, tree.rooted(link.to, node, link.length))
+		}
+	}
+	return tnode
+}
+
+// Parsimony maps each variant onto the branches of the tree by Fitch parsimony,
+// with every variant absent at the root where that is as parsimonious
+func (tree *Haptree) Parsimony() {
+	universe := bitsy.New()
+	for _, leaf := range tree.leaves {
+		universe.Or(leaf.hap.bitset)
+	}
+	tree.fitchdown(tree.root, universe)
+	tree.root.state = new(bitsy.Set).SetAndNot(tree.root.can1, tree.root.can0)
+	tree.Score = 0
+	for _, child := range tree.root.children {
+		tree.fitchup(child, tree.root)
+	}
+	tree.parsimony = true
+	fmt.Println("parsimony changes on the tree", tree.Score)
+}
+
+// fitchdown sets the state sets from the leaves up
+func (tree *Haptree) fitchdown(node *treenode, universe *bitsy.Set) {
+	if len(node.children) == 0 {
+		node.can1 = new(bitsy.Set).Set(node.hap.bitset)
+		node.can0 = new(bitsy.Set).SetAndNot(universe, node.hap.bitset)
+		return
+	}
+	for i, child := range node.children {
+		tree.fitchdown(child, universe)
+		if i == 0 {
+			node.can0 = new(bitsy.Set).Set(child.can0)
+			node.can1 = new(bitsy.Set).Set(child.can1)
+			continue
+		}
+		// intersect the state sets where they overlap, union them where they don't
+		both0 := new(bitsy.Set).SetAnd(node.can0, child.can0)
+		both1 := new(bitsy.Set).SetAnd(node.can1, child.can1)
+		disjoint := new(bitsy.Set).SetAndNot(universe, new(bitsy.Set).SetOr(both0, both1))
+		node.can0 = both0.Or(new(bitsy.Set).SetAnd(disjoint, new(bitsy.Set).SetOr(node.can0, child.can0)))
+		node.can1 = both1.Or(new(bitsy.Set).SetAnd(disjoint, new(bitsy.Set).SetOr(node.can1, child.can1)))
+	}
+}
+
+// fitchup picks each node's states from its parent's, keeping the parent state where the node allows it
+func (tree *Haptree) fitchup(node *treenode, parent *treenode) {
+	keep := new(bitsy.Set).SetAnd(parent.state, node.can1)   // present in the parent, and may be here
+	forced := new(bitsy.Set).SetAndNot(node.can1, node.can0) // must be present here
+	node.state = keep.Or(forced)
+	if len(node.children) == 0 {
+		node.state = new(bitsy.Set).Set(node.hap.bitset)
+	}
+	node.gained = setlist(new(bitsy.Set).SetAndNot(node.state, parent.state))
+	node.lost = setlist(new(bitsy.Set).SetAndNot(parent.state, node.state))
+	tree.Score += len(node.gained) + len(node.lost)
+	for _, child := range node.children {
+		tree.fitchup(child, node)
+	}
+}
+
+// Leaves returns the IDs of the haplotypes in the tree
+func (tree *Haptree) Leaves() []int {
+	IDs := make([]int, len(tree.leaves))
+	for i, leaf := range tree.leaves {
+		IDs[i] = leaf.hap.ID
+	}
+	return IDs
+}
+
+// taxon names a leaf by haplotype ID
+func taxon(hinfo *haplo) string {
+	return "h" + strconv.Itoa(hinfo.ID)
+}
+
+// newick writes the subtree at node; leaves carry their count, and after Parsimony
+// branches carry any variants gained and lost as [&gained="1,2",lost="3"] comments
+func (tree *Haptree) newick(writer *strings.Builder, node *treenode, isroot bool) {
+	if len(node.children) > 0 {
+		writer.WriteString("(")
+		for i, child := range node.children {
+			if i > 0 {
+				writer.WriteString(",")
+			}
+			tree.newick(writer, child, false)
+		}
+		writer.WriteString(")")
+	}
+	if node.hap != nil {
+		writer.WriteString(taxon(node.hap))
+	}
+	comments := make([]string, 0)
+	if node.hap != nil {
+		comments = append(comments, "count="+strconv.Itoa(node.hap.hapcount))
+	}
+	if tree.parsimony && len(node.gained) > 0 {
+		comments = append(comments, fmt.Sprintf("gained=\"%s\"", idlist(node.gained)))
+	}
+	if tree.parsimony && len(node.lost) > 0 {
+		comments = append(comments, fmt.Sprintf("lost=\"%s\"", idlist(node.lost)))
+	}
+	if len(comments) > 0 {
+		writer.WriteString("[&" + strings.Join(comments, ",") + "]")
+	}
+	if !isroot {
+		writer.WriteString(":" + strconv.FormatFloat(node.length, 'f', -1, 64))
+	}
+}
+
+// Newick returns the tree in Newick format
+func (tree *Haptree) Newick() string {
+	var writer strings.Builder
+	tree.newick(&writer, tree.root, true)
+	writer.WriteString(";")
+	return writer.String()
+}
+
+// NewickPrint outputs the tree in Newick format
+func (tree *Haptree) NewickPrint(treefile string) {
+	fmt.Println("Opening Newick Tree Output File", treefile)
+	ftout, err := os.Create(treefile)
+	globals.Check(err)
+	defer ftout.Close()
+	twriter := bufio.NewWriter(ftout)
+	defer twriter.Flush() // need this to get output
+	fmt.Fprintln(twriter, tree.Newick())
+}
+
+// NexusPrint outputs the tree in a Nexus file, with a taxa block giving each haplotype's variants
+func (tree *Haptree) NexusPrint(treefile string) {
+	fmt.Println("Opening Nexus Tree Output File", treefile)
+	ftout, err := os.Create(treefile)
+	globals.Check(err)
+	defer ftout.Close()
+	twriter := bufio.NewWriter(ftout)
+	defer twriter.Flush() // need this to get output
+
+	fmt.Fprintln(twriter, "#NEXUS")
+	fmt.Fprintln(twriter, "BEGIN TAXA;")
+	fmt.Fprintf(twriter, "\tDIMENSIONS NTAX=%d;\n", len(tree.leaves))
+	fmt.Fprintln(twriter, "\tTAXLABELS")
+	for _, leaf := range tree.leaves {
+		fmt.Fprintf(twriter, "\t\t%s[&count=%d,variants=\"%s\"]\n", taxon(leaf.hap), leaf.hap.hapcount, idlist(leaf.hap.variants))
+	}
+	fmt.Fprintln(twriter, "\t;")
+	fmt.Fprintln(twriter, "END;")
+	fmt.Fprintln(twriter, "BEGIN TREES;")
+	fmt.Fprintf(twriter, "\tTREE haplotypes = [&R] %s\n", tree.Newick())
+	fmt.Fprintln(twriter, "END;")
+}
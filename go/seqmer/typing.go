@@ -0,0 +1,115 @@
+package seqmer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	bitsy "github.com/yourbasic/bit"
+)
+
+//
+// //  single sequence typing // //
+//
+
+// Stretch is a run of non-reference kmers between two reference kmers that is not a known variant
+type Stretch struct {
+	Offset   int    // start of the prev kmer in the sequence, 0-based, after orienting
+	Prev     string // the reference kmers either side
+	Next     string
+	Deviants int    // number of non-reference kmers
+	Bases    string // the sequence between prev and next, "" where they overlap (eg a deletion)
+}
+
+// Seqtype holds the typing of one sequence
+type Seqtype struct {
+	Name         string
+	Flipped      bool       // reverse complemented to the reference strand
+	Known        []int      // IDs of the known variants, in sequence order
+	Novel        []*Stretch // stretches matching no known variant
+	Masked       int        // stretches with Ns, neither known nor novel
+	Nearest      int        // ID of the nearest haplotype, -1 if there are none
+	NearestCount int
+	Distance     int // variants that differ between the sequence and the nearest haplotype
+}
+
+// Typer types sequences one at a time against reference kmers, a catalogue of known variants,
+// and optionally known haplotypes, so new sequences can be typed without rerunning HapBuilder
+type Typer struct {
+	refmers *Oligos
+	vars    *Variants
+	haps    *Haplotypes
+}
+
+// Init sets up the typer; vars must have been Read, and haps may be nil
+func (typer *Typer) Init(refmers *Oligos, vars *Variants, haps *Haplotypes) {
+	if vars.free {
+		fmt.Println("Exiting, typing needs a variant catalogue, read in with Variants.Read")
+		os.Exit(1)
+	}
+	typer.refmers = refmers
+	typer.vars = vars
+	typer.haps = haps
+}
+
+// lookup finds the known variant with the prev, next and midmer of vinfo, nil if there is none;
+// unlike getVarMatch it never adds to the matches
+func (vars *Variants) lookup(vinfo *variant) *variant {
+	if m := vars.matches[vinfo.prev]; m != nil {
+		if m2 := m.key1[vinfo.next]; m2 != nil {
+			return m2.key2[getMidmer(vinfo)]
+		}
+	}
+	return nil
+}
+
+// Type finds the known variants and novel stretches of a sequence, as HapBuilder would,
+// and its nearest haplotype; stretches without a reference kmer on both sides are left out
+func (typer *Typer) Type(name string, seq string) *Seqtype {
+	st := &Seqtype{Name: name, Nearest: -1}
+	seq, st.Flipped = typer.refmers.Orient(strings.ToUpper(seq))
+	klen := typer.refmers.klen
+	prevpos := -1
+	deviants := make([]string, 0)
+	for i := 0; i < len(seq)-klen+1; i++ {
+		kmer := seq[i : i+klen]
+		if typer.refmers.kcount[typer.refmers.key(kmer)] == 0 {
+			if prevpos >= 0 {
+				deviants = append(deviants, kmer)
+			}
+			continue
+		}
+		if prevpos >= 0 && len(deviants) > 0 {
+			vinfo := &variant{prev: seq[prevpos : prevpos+klen], next: kmer, deviants: deviants}
+			if vinfo.hasNs() {
+				st.Masked++
+			} else if known := typer.vars.lookup(vinfo); known != nil {
+				st.Known = append(st.Known, known.ID)
+			} else {
+				stretch := &Stretch{Offset: prevpos, Prev: vinfo.prev, Next: vinfo.next, Deviants: len(deviants)}
+				if i > prevpos+klen {
+					stretch.Bases = seq[prevpos+klen : i]
+				}
+				st.Novel = append(st.Novel, stretch)
+			}
+			deviants = make([]string, 0)
+		}
+		prevpos = i
+	}
+	typer.nearest(st)
+	return st
+}
+
+// nearest sets the haplotype differing from the sequence by fewest known variants, the more common on ties
+func (typer *Typer) nearest(st *Seqtype) {
+	if typer.haps == nil {
+		return
+	}
+	known := bitsy.New(st.Known...)
+	for _, hinfo := range typer.haps.haplist {
+		distance := new(bitsy.Set).SetXor(known, hinfo.bitset).Size()
+		if st.Nearest < 0 || distance < st.Distance || (distance == st.Distance && hinfo.hapcount > st.NearestCount) {
+			st.Nearest, st.NearestCount, st.Distance = hinfo.ID, hinfo.hapcount, distance
+		}
+	}
+}
@@ -59,6 +59,7 @@ func main() {
 
 	refmers := new(seqmer.Oligos) // create global;
 	refmers.Init(globs.Geti("klen"), globs.Getf("kcountfile"), globs.Getb("printNs"), globs.Geti("kminprint"))
+	refmers.SetCanonical(globs.Getb("canonical")) // a flag in the kinfile header takes precedence
 	refmers.Readk(globs.Getf("kinfile")) // read kmer and counts
 
 	// main program here
@@ -67,16 +68,42 @@ func main() {
 	// setup
 	seqs := new(seqmer.Sequences) // create global;
 	seqs.Init(globs.Getf("seqfile"), globs.Geti("minseqlen"), globs.Geti("linelimit"), globs.Geti("minline"), globs.Getb("recordseq"), globs.Gets("filetype"), globs.Getb("dofilter"))
+	meta := new(seqmer.Metadata) // sequence metadata from headers and an optional metadata table
+	meta.Init(globs.Getf("metafile"), globs.Gets("headerpattern"))
+	seqs.SetMetadata(meta)
+	seqs.SetFilter(globs.Getf("seqnamefilter"), globs.Gets("filterdir"), globs.Gets("seqnameregex"), globs.Gets("datefrom"), globs.Gets("dateto"), globs.Gets("countries"))
 	qnkmers := makeKmers(seqs, globs, "qnotk_")
 	vars := new(seqmer.Variants) // create global
 	vars.Init(globs.Geti("klen"), globs.Getf("varfile"), globs.Geti("kminprint"))
+	if varmetafile := globs.Getf("varmetafile"); varmetafile != "empty" {
+		vars.Metafile = varmetafile // breakdown of variant counts by time and place
+	}
+	vars.SetPolicy(globs.Gets("npolicy"), globs.Getb("openended")) // variants with Ns, and at sequence ends
+	store := new(seqmer.Store) // persistent variants across batches, if set
+	if vardb := globs.Getf("vardb"); vardb != "empty" {
+		store.Open(vardb, globs.Geti("klen"))
+		store.AttachVariants(vars) // stored variants keep their IDs, new ones are numbered on
+	}
 
 	// do something useful
 	seqs.VarFind(qnkmers, refmers, vars) // find and record variants, count qnkmers
 
 	// close out
+	if store.File != "" {
+		store.Append(globs.Getf("seqfile")) // the batch is named for its sequence file; before Collapse, so the store keeps raw counts
+	}
+	if globs.Getb("collapse") {
+		vars.Collapse(globs.Geti("collapsemax"), globs.Getn("collapseratio")) // fold sequencing errors into their parent variants
+	}
 	qnkmers.Kprint()
 	vars.Print()
+	if vars.Metafile != "" {
+		vars.MetaPrint()
+	}
+	if globs.Getb("collapse") {
+		vars.ClusterPrint()
+	}
+	vars.DropPrint()
 
 	// end main code
 
@@ -91,5 +118,7 @@ func makeKmers(seqs *seqmer.Sequences, globs *globals.Params, kmerID string) *se
 	qnkmers := new(seqmer.Oligos)
 	qnkmers.Init(globs.Geti("klen"), globs.Getf("kcountfile"), globs.Getb("printNs"), globs.Geti("kminprint"))
 	qnkmers.Getoutfile(kmerID + globs.Gets("dorevcomp") + "_" + qbasename)
+	qnkmers.SetRevcomp(globs.Getb("orient")) // orient query sequences to the reference strand
+	qnkmers.SetCanonical(globs.Getb("canonical"))
 	return qnkmers
 }
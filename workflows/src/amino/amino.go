@@ -304,21 +304,76 @@ func GetChanges(ref_aa , alt_aa string, cstart int) []Change {
 	return changes
 }
 
+// Get the alleles of a vcf record in the form the Apply functions expect.
+// pos is the first changed base for SNP/DEL and the base the change follows
+// for INS/COMPOUND, end is the last deleted base (DEL) or the first base
+// after the change (INS/COMPOUND), and compound alleles are gapped.
+// Records with spec padded alleles are converted, and the legacy classify
+// alleles (DEL/INS placeholders, gapped compound) are passed through.
+// Symbolic <DEL> alleles become a deletion from POS+1 to END.
+func RecordAlleles(record *vcf.Record) (int, int, string, string) {
+	pos := record.Pos
+	ref_seq := record.Ref
+	alt_seq := record.Alt
+	end := pos + len(ref_seq) - 1
+	if e, ok := record.Info["END"]; ok {
+		end, _ = strconv.Atoi(e[0])
+	}
+	legacy := end != pos + len(ref_seq) - 1
+
+	switch record.Info["VARTYPE"][0] {
+	case "DEL", "DEL_REPEAT":
+		if alt_seq == "<DEL>" {
+			return pos + 1, end, ref_seq[1:], ""
+		} else if alt_seq != "DEL" { // padded: skip the preceding base
+			return pos + 1, pos + len(ref_seq) - 1, ref_seq[1:], ""
+		}
+	case "INS":
+		if ref_seq != "INS" { // padded: the ins follows pos
+			return pos, pos + 1, "", alt_seq[1:]
+		}
+	case "COMPOUND":
+		if legacy {
+			break
+		}
+		// padded alleles share their first base with the reference,
+		// otherwise the change follows the base before pos
+		if ref_seq[0] == alt_seq[0] && (len(ref_seq) == 1 || len(alt_seq) == 1) {
+			ref_seq, alt_seq = ref_seq[1:], alt_seq[1:]
+		} else {
+			pos--
+		}
+		end = pos + len(ref_seq) + 1
+		if len(ref_seq) == 0 || len(alt_seq) == 0 {
+			ref_seq = ref_seq + strings.Repeat("-", len(alt_seq))
+			alt_seq = alt_seq + strings.Repeat("-", len(ref_seq) - len(alt_seq))
+		} else {
+			ref_align, alt_align, err := AlignSequences(ref_seq, alt_seq, true)
+			Check(err)
+			ref_seq, alt_seq = ref_align, alt_align
+		}
+	}
+	return pos, end, ref_seq, alt_seq
+}
+
 // given and vcf record, gene start, get the amino acid changes
 func AminoAcidChanges(ref *fastaseq.ContiguousReference, record *vcf.Record,
 		gene_intervals map[string]Interval,
 		codon_table map[string]byte) (string, string) {
 
+	// the inserted sequence of a symbolic <INS> isn't known, nor how far
+	// an open ended variant (a single breakend) reaches
+	if record.Alt == "<INS>" || record.Info["VARTYPE"][0] == "OPEN" {
+		return "AMBIGUOUS", "."
+	}
+
 	// get relevant information from vcf line
-	pos := record.Pos
-	ref_seq := record.Ref
-	alt_seq := record.Alt
+	pos, end, ref_seq, alt_seq := RecordAlleles(record)
 	// println("")
 	// println("============================================================")
 	// println("ref_seq: ", ref_seq)
 	// println("alt_seq: ", alt_seq)
 	// println("------------------------------------------------------------")
-	end , _:= strconv.Atoi(record.Info["END"][0])
 
 	gene := record.Info["GENE"][0]
 	gstart := gene_intervals[gene].Start
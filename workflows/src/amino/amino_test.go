@@ -514,6 +514,35 @@ func TestAminoAcidChanges(t *testing.T) {
 		compare(res_frameshift, corr_frameshift, t)
 	})
 
+	// The same variants with spec compliant (padded, ungapped) alleles
+	// as written by classify must give the same changes.
+	padded := []struct {
+		name, line, changes, frameshift string
+	}{
+		{"DEL_PADDED", "NC_045512.2	26157	3465	TGTTA	T	.	.	VARTYPE=DEL;END=26161;GENE=ORF3a",
+			"256V>del,257N>del", "true"},
+		{"INS_PADDED", "NC_045512.2	22205	3505	G	GCGGCAGGCT	.	.	VARTYPE=INS;END=22205;GENE=S",
+			"215D>A,215ins>A,215ins>G,215ins>Y", "false"},
+		{"COMPOUND_DEL_UNGAPPED", "NC_045512.2	28274	108	ATGTCTGAT	TGTCTCTA	.	.	VARTYPE=COMPOUND;END=28282;GENE=N",
+			"1M>C,2S>L,3D>*,4N>del", "true"},
+		{"COMPOUND_INS_UNGAPPED", "NC_045512.2	11083	5381	G	TTTT	.	.	VARTYPE=COMPOUND;END=11083;GENE=ORF1a",
+			"3606L>F,3606ins>F", "false"},
+		{"DEL_SYMBOLIC", "NC_045512.2	26157	3465	T	<DEL>	.	.	VARTYPE=DEL;END=26161;SVTYPE=DEL;SVLEN=-4;GENE=ORF3a",
+			"256V>del,257N>del", "true"},
+		{"COMPOUND_SNP_UNGAPPED", "NC_045512.2	28875	3413	GCAGTAGGGGAAC	TCAGTAGGGGAAT	.	.	VARTYPE=COMPOUND;END=28887;GENE=N",
+			"201S>I,205T>I", "false"},
+	}
+	for _, p := range padded {
+		t.Run(p.name, func(t *testing.T) {
+			rec, err := vcf.ParseVCFRecord(p.line)
+			Check(err)
+			res_changes, res_frameshift :=
+				AminoAcidChanges(ref, rec, gene_intervals, codon_table)
+			compare(res_changes, p.changes, t)
+			compare(res_frameshift, p.frameshift, t)
+		})
+	}
+
 
 }
 
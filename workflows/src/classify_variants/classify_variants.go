@@ -2,7 +2,10 @@ package classify_variants
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -12,6 +15,7 @@ import (
 
 	arg "github.com/alexflint/go-arg"
 
+	"AnVir/seqmer"
 	"annotation/fastaseq"
 	. "annotation/utils"
 	"annotation/vcf"
@@ -23,24 +27,61 @@ const ID = 0
 const COUNT = 2
 const SEQ = 5
 
+// defaults for the structural variant settings
+const DEFAULT_SV_LEN = 100
+const DEFAULT_MAX_SPAN = 1000
+const DEFAULT_MIN_UNIQUENESS = 1.0
+
+// cap on the anchor placement confidence, as for read aligners
+const MAX_MAPQ = 60
+
 type cliargs struct {
 	Reference string `arg:"--reference,required,help:Reference fasta."`
 	Variants  string `arg:"--variants,required,help:Variants table."`
 	Outfile   string `arg:"--outfile,required,help:Output vcf"`
 	Threads   int    `arg:"--threads,help:n concurrent threads."`
 	K         int    `arg:"--k,required,help:kmer length"`
+	Decompose bool   `arg:"--decompose,help:split compound variants into atomic SNP/INS/DEL records."`
+	SVLen     int     `arg:"--sv-len,help:events spanning at least this many bases are written as symbolic <DEL>/<INS>."`
+	MaxSpan   int     `arg:"--max-span,help:largest reference distance between anchors; anything larger is treated as a spurious anchor mapping."`
+	MinUniq   float64 `arg:"--min-uniqueness,help:structural variants with an anchor uniqueness below this are FILTERed as MULTIMAP; 0 turns the filter off."`
+	IDMap     string  `arg:"--id-map,help:Output a table mapping the variant table IDs to the stable VID and KVID of each record."`
 }
 func (c cliargs) Description() string {
 	return "Classify variants provided in {variants} with respect to the {reference}."
 }
 
 
+// Settings that control how the variants are classified and written.
+type Options struct {
+	K         int  // kmer length
+	Decompose bool // split compound variants into atomic records
+	SVLen     int  // min length of symbolic structural variants
+	MaxSpan   int  // max reference distance between the anchors
+	MinUniq   float64 // min anchor uniqueness for unfiltered SVs, negative for no filter
+}
+
+// Fill in the defaults for the structural variant settings left at zero.
+func (opts Options) withDefaults() Options {
+	if opts.SVLen == 0 {
+		opts.SVLen = DEFAULT_SV_LEN
+	}
+	if opts.MaxSpan == 0 {
+		opts.MaxSpan = DEFAULT_MAX_SPAN
+	}
+	if opts.MinUniq < 0 {
+		opts.MinUniq = DEFAULT_MIN_UNIQUENESS
+	}
+	return opts
+}
+
 type Variant struct {
 	// basic metadata
 	id string    
 	count string // its read as string so why bother right? :)
 
-    // genomic interval of variant
+    // genomic interval of variant, start is the vcf POS
+	// and end is POS + len(ref_allele) - 1
 	start int
 	end int
 
@@ -48,12 +89,181 @@ type Variant struct {
 	variant_type string
 
     // ref seq at the variant genomic position
+	// indels are padded with the preceding reference base
 	ref_allele string
 
 	// alt seq at the genomic position
-	// snp: base, del: DEL, ins: inserted sequence,
-	// compound: aligned seq to the ref
+	// snp: base, del: padding base, ins: padding base + inserted sequence,
+	// compound: the (ungapped) variant sequence
+	// structural variants: symbolic <DEL> or <INS>
 	alt_allele string    
+
+	// structural variants only: length difference of alt vs ref
+	svlen int
+
+	// uniqueness (1/number of reference hits) of the pre/post anchors
+	uniqueness [2]float64
+
+	// vcf FILTER, "." if unset
+	filter string
+
+	// phred scaled confidence that the anchors are placed correctly
+	mapq int
+
+	// "start" or "end" for a variant with no anchor on that side
+	open string
+}
+
+// Create a symbolic structural variant between the anchors.  The record is
+// placed on the base before the event, as for padded indels.
+func newStructuralVariant(id string, count string, anchors Pair[Interval, Interval],
+		svlen int, contiguous_ref *fastaseq.ContiguousReference) Variant {
+	v := Variant{
+		id: id, count: count,
+		start: anchors.Fst.End,
+		end: anchors.Snd.Start - 1,
+		ref_allele: contiguous_ref.Query(anchors.Fst.End, anchors.Fst.End),
+		svlen: svlen,
+	}
+	if svlen < 0 {
+		v.variant_type = "DEL"
+		v.alt_allele = "<DEL>"
+	} else {
+		v.variant_type = "INS"
+		v.alt_allele = "<INS>"
+	}
+	return v
+}
+
+// The vcf record of the variant on contig, with the VARTYPE, END and COUNT
+// INFO fields; GetVariants adds the rest.
+func (v Variant) Record(contig string) *vcf.Record {
+	return vcf.VcfRecord().
+		SetChrom(contig).
+		SetPos(v.start).
+		SetID(v.id).
+		SetRef(v.ref_allele).
+		SetAlt(v.alt_allele).
+		SetQual(".").SetFilter(v.filter).
+		AddInfo("VARTYPE", v.variant_type).
+		AddInfo("END", strconv.Itoa(v.end)).
+		AddInfo("COUNT", v.count)
+}
+
+// Build vcf alleles for the ref/alt sequences starting at the 1-based
+// position start.  When either allele would be empty (simple indels) both
+// are padded with the reference base before start, as the vcf spec asks.
+// An event at the very start of the contig is padded with the base after.
+func vcfAlleles(start int, ref_seq string, alt_seq string,
+		contiguous_ref *fastaseq.ContiguousReference) (int, string, string) {
+	if len(ref_seq) > 0 && len(alt_seq) > 0 {
+		return start, ref_seq, alt_seq
+	}
+	if start > 1 {
+		pad := contiguous_ref.Query(start - 1, start - 1)
+		return start - 1, pad + ref_seq, pad + alt_seq
+	}
+	pad := contiguous_ref.Query(start + len(ref_seq), start + len(ref_seq))
+	return start, ref_seq + pad, alt_seq + pad
+}
+
+// Create a variant from unpadded ref/alt sequences starting at start.
+func newVariant(id string, count string, variant_type string, start int,
+		ref_seq string, alt_seq string,
+		contiguous_ref *fastaseq.ContiguousReference) Variant {
+	pos, ref_allele, alt_allele := vcfAlleles(start, ref_seq, alt_seq, contiguous_ref)
+	return Variant{
+		id: id, count: count,
+		start: pos,
+		end: pos + len(ref_allele) - 1,
+		variant_type: variant_type,
+		ref_allele: ref_allele,
+		alt_allele: alt_allele,
+	}
+}
+
+// Left align and trim vcf alleles against the reference, so that each
+// mutation has one representation however it was found.  Alleles are upper
+// cased, as soft masked (lower case) bases are the same mutation:
+//   ref: ATCGATATGGCGCGCAT
+//   pos: 14 ref: G alt: GCG ==> pos: 9 ref: G alt: GGC
+func NormalizeAlleles(pos int, ref string, alt string,
+		contiguous_ref *fastaseq.ContiguousReference) (int, string, string) {
+	ref, alt = strings.ToUpper(ref), strings.ToUpper(alt)
+	if strings.HasPrefix(alt, "<") || strings.Contains(alt, ".") || ref == alt {
+		return pos, ref, alt
+	}
+	// trim the common suffix, extending to the left while either allele is empty
+	for {
+		if len(ref) > 0 && len(alt) > 0 && ref[len(ref)-1] == alt[len(alt)-1] {
+			ref, alt = ref[:len(ref)-1], alt[:len(alt)-1]
+		} else if (len(ref) == 0 || len(alt) == 0) && pos > 1 {
+			pos--
+			pad := strings.ToUpper(contiguous_ref.Query(pos, pos))
+			ref, alt = pad+ref, pad+alt
+		} else {
+			break
+		}
+	}
+	// an event at the start of the contig is padded with the base after
+	if len(ref) == 0 || len(alt) == 0 {
+		pad := strings.ToUpper(contiguous_ref.Query(pos+len(ref), pos+len(ref)))
+		ref, alt = ref+pad, alt+pad
+	}
+	// trim the common prefix, keeping a base
+	for len(ref) > 1 && len(alt) > 1 && ref[0] == alt[0] {
+		ref, alt = ref[1:], alt[1:]
+		pos++
+	}
+	return pos, ref, alt
+}
+
+// Stable ID of a vcf variant, the same across runs and machines: the first
+// 16 hex digits of the sha256 of CHROM:POS:REF:ALT after normalizing, with
+// the END of symbolic alleles, which carry no sequence.
+func VariantKey(chrom string, pos int, ref string, alt string, end int,
+		contiguous_ref *fastaseq.ContiguousReference) string {
+	pos, ref, alt = NormalizeAlleles(pos, ref, alt, contiguous_ref)
+	key := chrom + ":" + strconv.Itoa(pos) + ":" + ref + ":" + alt
+	if strings.HasPrefix(alt, "<") {
+		key += ":" + strconv.Itoa(end)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Split a gapped alignment into atomic SNP, INS and DEL variants.
+// start is the 1-based reference position of the first aligned base.
+//   ref: TG-CA      SNP  at start
+//   alt: tGaC-  ==> INS  before start+2
+//                   DEL  of start+3
+func DecomposeAlignment(id string, count string, start int,
+		ref_align string, alt_align string,
+		contiguous_ref *fastaseq.ContiguousReference) []Variant {
+	variants := make([]Variant, 0, 2)
+	refpos := start
+	for i := 0; i < len(ref_align); {
+		j := i + 1
+		switch {
+		case ref_align[i] == '-': // inserted bases, refpos doesn't move
+			for j < len(ref_align) && ref_align[j] == '-' { j++ }
+			variants = append(variants, newVariant(id, count, "INS", refpos,
+				"", alt_align[i:j], contiguous_ref))
+		case alt_align[i] == '-': // deleted bases
+			for j < len(alt_align) && alt_align[j] == '-' { j++ }
+			variants = append(variants, newVariant(id, count, "DEL", refpos,
+				ref_align[i:j], "", contiguous_ref))
+			refpos += j - i
+		case ref_align[i] != alt_align[i]:
+			variants = append(variants, newVariant(id, count, "SNP", refpos,
+				ref_align[i:j], alt_align[i:j], contiguous_ref))
+			refpos++
+		default:
+			refpos++
+		}
+		i = j
+	}
+	return variants
 }
 
 // Use this function to print some basic debug_info about the variant
@@ -93,6 +303,143 @@ func debug_info(id string, anchors Pair[Interval, Interval],
 }
 
 
+// Sequence spelled by the whole chain, anchors included.
+func chainSequence(variant_seq []string, k int) string {
+	var sb strings.Builder
+	sb.WriteString(variant_seq[0])
+	for _, s := range variant_seq[1:] {
+		sb.WriteByte(byte(s[k-1]))
+	}
+	return sb.String()
+}
+
+// Cost of placing the chain at the anchors: the anchors are extended
+// outward through the chain for as long as it agrees with the reference,
+// and the bases left over on either side are the (approximate) number of
+// edits needed to explain the variant at that placement.
+//   ref:   AAAAA GCAT    CCCCC
+//   chain: AAAAA GgAT    CCCCC  ==> extend 1 from the left, 2 from the right,
+//                                   cost 1
+func placementCost(chain string, k int, anchors Pair[Interval, Interval],
+		contiguous_ref *fastaseq.ContiguousReference) int {
+	ref_distance := anchors.Snd.Start - anchors.Fst.End - 1
+	alt_len := len(chain) - 2*k
+	if alt_len < 0 {
+		// anchors overlap in the chain (deleted repeat)
+		return ref_distance - alt_len
+	}
+	alt_seq := chain[k:k+alt_len]
+	ref_seq := ""
+	if ref_distance > 0 {
+		ref_seq = contiguous_ref.Query(anchors.Fst.End + 1, anchors.Snd.Start - 1)
+	}
+	l := 0
+	for l < len(alt_seq) && l < len(ref_seq) && alt_seq[l] == ref_seq[l] {
+		l++
+	}
+	r := 0
+	for r < len(alt_seq) - l && r < len(ref_seq) - l &&
+		alt_seq[len(alt_seq)-1-r] == ref_seq[len(ref_seq)-1-r] {
+		r++
+	}
+	return Max(len(alt_seq), len(ref_seq)) - l - r
+}
+
+// Pick the placement(s) of the anchors that explain the chain with the
+// fewest edits, dropping pairs further apart than max_span.  Also returns a
+// MAPQ-like confidence: ties share -10log10(1 - 1/n_best), otherwise it grows
+// with the cost gap to the runner up.
+func selectAnchors(pairs []Pair[Interval, Interval], chain string, k int,
+		max_span int, contiguous_ref *fastaseq.ContiguousReference,
+		) ([]Pair[Interval, Interval], int) {
+	best := make([]Pair[Interval, Interval], 0, 1)
+	best_cost, next_cost := -1, -1
+	for _, anchors := range pairs {
+		if anchors.Snd.Start - anchors.Fst.End - 1 > max_span {
+			// probably spurious mapping of an anchor seq
+			continue
+		}
+		cost := placementCost(chain, k, anchors, contiguous_ref)
+		switch {
+		case best_cost < 0 || cost < best_cost:
+			if best_cost >= 0 {
+				next_cost = best_cost
+			}
+			best = append(best[:0], anchors)
+			best_cost = cost
+		case cost == best_cost:
+			best = append(best, anchors)
+		case next_cost < 0 || cost < next_cost:
+			next_cost = cost
+		}
+	}
+
+	switch {
+	case len(best) > 1:
+		mapq := -10 * math.Log10(1 - 1 / float64(len(best)))
+		return best, int(math.Round(mapq))
+	case next_cost < 0:
+		return best, MAX_MAPQ
+	default:
+		return best, Min(MAX_MAPQ, 10 * (next_cost - best_cost))
+	}
+}
+
+// Classify a variant with an anchor on one side only ("-" for the other), as
+// found at the ends of sequences or split at Ns.  How far it reaches is not
+// known, so it is written as a single breakend at the last base that agrees
+// with the reference: the novel bases beyond, then ".".
+//   ref:   AAAAAGCATTT
+//   chain: AAAAAGCgTa    ==> POS: C  REF: C  ALT: CgTa.
+// There is a record for each placement of the anchor.
+func classifyOpen(id string, count string, variant_seq []string, opts Options,
+		windowed_ref *fastaseq.WindowedReference,
+		contiguous_ref *fastaseq.ContiguousReference) []Variant {
+	k := opts.K
+	n := len(variant_seq)
+	open_start := variant_seq[0] == "-"
+	anchor, side := variant_seq[0], 0
+	chain := chainSequence(variant_seq[:n-1], k)
+	if open_start {
+		anchor, side = variant_seq[n-1], 1
+		chain = chainSequence(variant_seq[1:], k)
+	}
+
+	placements := windowed_ref.Query(anchor)
+	mapq := MAX_MAPQ
+	if len(placements) > 1 {
+		mapq = int(math.Round(-10 * math.Log10(1 - 1 / float64(len(placements)))))
+	}
+	variants := make([]Variant, 0, 1)
+	for _, loc := range placements {
+		v := Variant{id: id, count: count, variant_type: "OPEN",
+			mapq: mapq, filter: "."}
+		v.uniqueness[side] = windowed_ref.Uniqueness(anchor)
+		if open_start {
+			// move the anchor left for as long as the chain agrees
+			novel, pos := chain[:len(chain)-k], loc.Start
+			for len(novel) > 0 && pos > 1 &&
+				novel[len(novel)-1] == contiguous_ref.Query(pos-1, pos-1)[0] {
+				novel, pos = novel[:len(novel)-1], pos-1
+			}
+			v.start, v.end, v.open = pos, pos, "start"
+			v.ref_allele = contiguous_ref.Query(pos, pos)
+			v.alt_allele = "." + novel + v.ref_allele
+		} else {
+			novel, pos := chain[k:], loc.End
+			for len(novel) > 0 && pos < contiguous_ref.Length() &&
+				novel[0] == contiguous_ref.Query(pos+1, pos+1)[0] {
+				novel, pos = novel[1:], pos+1
+			}
+			v.start, v.end, v.open = pos, pos, "end"
+			v.ref_allele = contiguous_ref.Query(pos, pos)
+			v.alt_allele = v.ref_allele + novel + "."
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
 // Take the deviant sequences and merge into a single string.
 func MergeDeviants(variant_seq []string, k int) string{
 	var sb strings.Builder
@@ -112,24 +459,44 @@ func MergeDeviants(variant_seq []string, k int) string{
 // and the set of deviant sequences, determine the variant type/genomic position.
 // In some cases there could be multiple possible variants return if the anchor
 // sequences align to multiple places in the reference in a valid way.
-func ClassifyVariant(id string, count string, variant_seq []string, k int,
+func ClassifyVariant(id string, count string, variant_seq []string, opts Options,
+		windowed_ref *fastaseq.WindowedReference,
+		contiguous_ref *fastaseq.ContiguousReference) []Variant{
+	return classifyVariant(id, count, variant_seq, opts.withDefaults(),
+		windowed_ref, contiguous_ref)
+}
+
+func classifyVariant(id string, count string, variant_seq []string, opts Options,
 		windowed_ref *fastaseq.WindowedReference,
 		contiguous_ref *fastaseq.ContiguousReference) []Variant{
 	
 	// get anchor sequences
+	k := opts.K
 	n := len(variant_seq)
+	if n < 3 || (variant_seq[0] == "-" && variant_seq[n-1] == "-") {
+		// no deviants, or no anchor to place them by: nothing to classify
+		return []Variant{}
+	}
+	if variant_seq[0] == "-" || variant_seq[n-1] == "-" {
+		return classifyOpen(id, count, variant_seq, opts, windowed_ref, contiguous_ref)
+	}
 	pre_anchor := variant_seq[0]
 	post_anchor := variant_seq[n-1]
 
-	// get all possible "alginments" of the pre/post anchors
-	pre_intervals := windowed_ref.Query(pre_anchor)
-	post_intervals := windowed_ref.Query(post_anchor)
-	interval_pairs := IntervalCartesionProduct(
-		pre_intervals, post_intervals)
+	// get all possible "alginments" of the pre/post anchors, and keep
+	// the ones that best explain the chain.  Repetitive anchors are
+	// disambiguated by how far they extend into the deviants.
+	interval_pairs, mapq := selectAnchors(IntervalCartesionProduct(
+		windowed_ref.Query(pre_anchor), windowed_ref.Query(post_anchor)),
+		chainSequence(variant_seq, k), k, opts.MaxSpan, contiguous_ref)
+
+	// an anchor that maps to many places is likely to give spurious events
+	uniqueness := [2]float64{
+		windowed_ref.Uniqueness(pre_anchor),
+		windowed_ref.Uniqueness(post_anchor),
+	}
 
-	// safe to assume at most 3 possibilities since chances
-	// of both pre/post intervals aligning to more than 1
-	// spot is pretty low.
+	// usually 1 placement, 2 for a deleted repeat
 	variants := make([]Variant, 0, 3)
 
 	// for each pair classify the variant
@@ -139,10 +506,8 @@ func ClassifyVariant(id string, count string, variant_seq []string, k int,
 
 		ref_distance := anchors.Snd.Start - anchors.Fst.End - 1
 		n_deviants := n - 2
+		n_variants := len(variants)
 
-		// TODO add to end of chain probably spurious mapping of an anchor seq
-		// if ref_distance > 100 {
-		// 	continue
 		if ref_distance == 1 && n_deviants == k {
 			/// Simple SNP
 			variants = append(variants, Variant{
@@ -160,15 +525,15 @@ func ClassifyVariant(id string, count string, variant_seq []string, k int,
 			// Simple DEL ------------------------------------------------------
 			// TODO if I prove that the above 2 properties are equivalient,
 			// then I can remove one of those
-			variants = append(variants, Variant{
-				id: id, count: count,
-				start: anchors.Fst.End + 1,
-				end: anchors.Snd.Start - 1,
-				variant_type: "DEL",
-				ref_allele: contiguous_ref.Query(
-					anchors.Fst.End + 1, anchors.Snd.Start - 1),
-				alt_allele: "DEL",
-			})
+			if ref_distance >= opts.SVLen {
+				variants = append(variants, newStructuralVariant(id, count,
+					anchors, -ref_distance, contiguous_ref))
+			} else {
+				variants = append(variants, newVariant(id, count, "DEL",
+					anchors.Fst.End + 1,
+					contiguous_ref.Query(anchors.Fst.End + 1, anchors.Snd.Start - 1),
+					"", contiguous_ref))
+			}
 		} else if n_deviants < k && ref_distance <= 0 {
 			/// DEL of repeated sequence
 			// TODO test further
@@ -181,61 +546,88 @@ func ClassifyVariant(id string, count string, variant_seq []string, k int,
 			// the deletion either occured in the suffix of the pre anchor
 			// or the prefix of the post anchor.
 			del_seq := SuffixPrefixOverlap(pre_anchor, post_anchor)
-			variants = append(variants, Variant{
-				id: id, count: count,
-				start: anchors.Fst.End - len(del_seq) + 1,
-				end: anchors.Fst.End,
-				variant_type: "DEL_REPEAT", // should this be its own type?
-				ref_allele: del_seq,
-				alt_allele: "DEL",
-			})
-			variants = append(variants, Variant{
-				id: id, count: count,
-				start: anchors.Snd.Start,
-				end: anchors.Snd.Start + len(del_seq) - 1,
-				variant_type: "DEL_REPEAT", // should this be its own type?
-				ref_allele: del_seq,
-				alt_allele: "DEL",
-			})
+			// should DEL_REPEAT be its own type?
+			variants = append(variants, newVariant(id, count, "DEL_REPEAT",
+				anchors.Fst.End - len(del_seq) + 1, del_seq, "", contiguous_ref))
+			variants = append(variants, newVariant(id, count, "DEL_REPEAT",
+				anchors.Snd.Start, del_seq, "", contiguous_ref))
 			
+		} else if ins_seq := merged_deviants[k-1:len(merged_deviants)-k+1];
+		ref_distance == 0 && len(ins_seq) >= opts.SVLen {
+				/// Large INS
+				variants = append(variants, newStructuralVariant(id, count,
+					anchors, len(ins_seq), contiguous_ref))
 		} else if ref_distance == 0 {
-				/// Simple INS
-				variants = append(variants, Variant{
-					id: id, count: count,
-					start: anchors.Fst.End, // 1 before the ins
-					end: anchors.Snd.Start, // 1 after the ins
-					variant_type: "INS",
-					ref_allele: "INS",
-					alt_allele: merged_deviants[k-1:len(merged_deviants)-k+1],
-				})
-		} else if ref_distance < 100 {
+				/// Simple INS, padded with the base before the ins
+				variants = append(variants, newVariant(id, count, "INS",
+					anchors.Snd.Start, "", ins_seq, contiguous_ref))
+		} else if ref_distance > 0 &&
+		(ref_distance >= opts.SVLen || len(ins_seq) >= opts.SVLen) {
+			/// Large replacement: the reference between the anchors is deleted
+			/// and novel sequence inserted in its place, both after the base
+			/// before the event; each part is symbolic only if it is large
+			if ref_distance >= opts.SVLen {
+				variants = append(variants, newStructuralVariant(id, count,
+					anchors, -ref_distance, contiguous_ref))
+			} else {
+				variants = append(variants, newVariant(id, count, "DEL",
+					anchors.Fst.End + 1,
+					contiguous_ref.Query(anchors.Fst.End + 1, anchors.Snd.Start - 1),
+					"", contiguous_ref))
+			}
+			if len(ins_seq) >= opts.SVLen {
+				variants = append(variants, newStructuralVariant(id, count,
+					Pair[Interval, Interval]{Fst: anchors.Fst, Snd: Interval{
+						Start: anchors.Fst.End + 1, End: anchors.Fst.End + 1}},
+					len(ins_seq), contiguous_ref))
+			} else if len(ins_seq) > 0 {
+				variants = append(variants, newVariant(id, count, "INS",
+					anchors.Fst.End + 1, "", ins_seq, contiguous_ref))
+			}
+		} else {
 			// catch all case for any type of compound variant --------------------
 			// TODO add more variety of tests
 			len_merged := len(merged_deviants)
 			ref_seq := contiguous_ref.Query(anchors.Fst.End + 1, anchors.Snd.Start - 1)
 			alt_seq := merged_deviants[k-1:len_merged-k+1]
-			ref_align, alt_align, err := AlignSequences(ref_seq, alt_seq, true)
-			Check(err)
-			variants = append(variants, Variant{
-				id: id, count: count,
-				start: anchors.Fst.End,
-				end:   anchors.Snd.Start,
-				variant_type: "COMPOUND",
-				ref_allele: ref_align,
-				alt_allele: alt_align,
-			})
+			if opts.Decompose {
+				ref_align, alt_align, err := AlignSequences(ref_seq, alt_seq, true)
+				Check(err)
+				variants = append(variants, DecomposeAlignment(id, count,
+					anchors.Fst.End + 1, ref_align, alt_align, contiguous_ref)...)
+			} else {
+				variants = append(variants, newVariant(id, count, "COMPOUND",
+					anchors.Fst.End + 1, ref_seq, alt_seq, contiguous_ref))
+			}
+		}
+
+		// tag the new variants with the anchor uniqueness/confidence, and
+		// filter structural variants whose anchors are not unique enough
+		for i := n_variants; i < len(variants); i++ {
+			variants[i].uniqueness = uniqueness
+			variants[i].mapq = mapq
+			variants[i].filter = "."
+			if variants[i].svlen != 0 &&
+				Minf(uniqueness[0], uniqueness[1]) < opts.MinUniq {
+				variants[i].filter = "MULTIMAP"
+			}
 		}
 	}
 	return variants
 }
 
-func GetVariants(variants_file string, ref_fasta string, k int, out *os.File) {
+// Classify the variants in variants_file and write them as a vcf to out.
+func GetVariants(variants_file string, ref_fasta string, opts Options, out *os.File) {
+	writeVariants(variants_file, ref_fasta, opts.withDefaults(), out)
+}
+
+func writeVariants(variants_file string, ref_fasta string, opts Options, out *os.File) {
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex // for concurrent writes to output
 
 	// load the reference into windowed and contiguous query structures
-	windowed_ref := fastaseq.LoadWindowedReference(ref_fasta, k)
+	windowed_ref := fastaseq.LoadWindowedReference(ref_fasta, opts.K)
 	contiguous_ref := fastaseq.LoadContiguousReference(ref_fasta)
 
 	// Write vcf header to stdout
@@ -246,6 +638,16 @@ func GetVariants(variants_file string, ref_fasta string, k int, out *os.File) {
 		AddInfo("END", "1", "Integer", "End position (closed interval)").
 		AddInfo("COUNT", "1", "Integer", "Number of occurrences.").
 		AddInfo("KMERS", ".", "String", "List of deviant kmer sequences bookended by the prev/next anchor sequences").
+		AddInfo("VID", "1", "String", "Stable ID of the normalized CHROM:POS:REF:ALT.").
+		AddInfo("KVID", "1", "String", "Stable ID of the kmer variant (the VID column of the variants table).").
+		AddInfo("SVTYPE", "1", "String", "Type of structural variant.").
+		AddInfo("SVLEN", "1", "Integer", "Length difference between the ALT and REF alleles of a structural variant.").
+		AddInfo("UNIQ", "2", "Float", "Uniqueness (1/number of reference hits) of the prev/next anchor sequences.").
+		AddInfo("MAPQ", "1", "Integer", "Phred scaled confidence in the placement of the anchors (max 60).").
+		AddInfo("OPEN", "1", "String", "Side (start or end) with no anchor; ALT is a single breakend.").
+		AddAlt("DEL", "Deletion relative to the reference").
+		AddAlt("INS", "Insertion of novel sequence relative to the reference").
+		AddFilter("MULTIMAP", "Structural variant with an anchor that maps to multiple reference positions").
 		Write(out)
 
 	f, err := os.Open(variants_file)
@@ -269,26 +671,40 @@ func GetVariants(variants_file string, ref_fasta string, k int, out *os.File) {
 			variantID := fields[ID]
 			count := fields[COUNT]
 			variant_seq := fields[SEQ:]
+			n := len(variant_seq)
+			kvid := seqmer.KmerVariantKey(strings.TrimPrefix(variant_seq[0], "-"),
+				variant_seq[1:n-1], strings.TrimPrefix(variant_seq[n-1], "-"))
 			// fmt.Printf("%s\n", variantID)
 
 			// get possible variants from this set of deviants
 			// TODO send the ID/count into the func and add fields to Variant struct
-			variants := ClassifyVariant(variantID, count, variant_seq, k,
+			variants := classifyVariant(variantID, count, variant_seq, opts,
 				windowed_ref, contiguous_ref)
 			mu.Lock()
 			for _, v := range variants {
-				vcf.VcfRecord().
-					SetChrom(contiguous_ref.Contig).
-					SetPos(v.start).
-					SetID(v.id).
-					SetRef(v.ref_allele).
-					SetAlt(v.alt_allele).
-					SetQual(".").SetFilter(".").
-					AddInfo("VARTYPE", v.variant_type).
-					AddInfo("END", strconv.Itoa(v.end)).
-					AddInfo("COUNT", v.count).
+				record := v.Record(contiguous_ref.Contig).
 					AddInfo("KMERS", variant_seq...).
-					Write(out)
+					AddInfo("VID", VariantKey(contiguous_ref.Contig, v.start,
+						v.ref_allele, v.alt_allele, v.end, contiguous_ref)).
+					AddInfo("KVID", kvid)
+				if v.svlen != 0 {
+					record.AddInfo("SVTYPE", v.variant_type).
+						AddInfo("SVLEN", strconv.Itoa(v.svlen))
+				}
+				uniq := []string{
+					strconv.FormatFloat(v.uniqueness[0], 'g', 3, 64),
+					strconv.FormatFloat(v.uniqueness[1], 'g', 3, 64)}
+				if v.open == "start" {
+					uniq[0] = "."
+				} else if v.open == "end" {
+					uniq[1] = "."
+				}
+				record.AddInfo("UNIQ", uniq...).
+					AddInfo("MAPQ", strconv.Itoa(v.mapq))
+				if v.open != "" {
+					record.AddInfo("OPEN", v.open)
+				}
+				record.Write(out)
 			}
 			mu.Unlock()
 		}(text)
@@ -296,9 +712,50 @@ func GetVariants(variants_file string, ref_fasta string, k int, out *os.File) {
 	wg.Wait()
 }
 
+// Write the table mapping the IDs of the variants table (the vcf ID) to the
+// stable IDs of each record of a classified vcf.
+func WriteIDMap(vcf_path string, map_path string) {
+	f, err := os.Open(vcf_path)
+	Check(err)
+	defer f.Close()
+	out, err := os.Create(map_path)
+	Check(err)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "ID\tVID\tKVID\tCHROM\tPOS\tREF\tALT")
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // long KMERS
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		record, err := vcf.ParseVCFRecord(line)
+		Check(err)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", record.ID,
+			strings.Join(record.Info["VID"], ","), strings.Join(record.Info["KVID"], ","),
+			record.Chrom, record.Pos, record.Ref, record.Alt)
+	}
+	Check(scanner.Err())
+}
+
 func Main() {
-	cli := cliargs{Threads: 1}
-	arg.MustParse(&cli)
+	cli := cliargs{Threads: 1, SVLen: DEFAULT_SV_LEN,
+		MaxSpan: DEFAULT_MAX_SPAN, MinUniq: DEFAULT_MIN_UNIQUENESS}
+	p := arg.MustParse(&cli)
+	if cli.SVLen < 1 || cli.MaxSpan < 1 {
+		p.Fail("--sv-len and --max-span must be at least 1")
+	}
+	if cli.MinUniq < 0 {
+		p.Fail("--min-uniqueness must be 0 or more")
+	}
+	opts := Options{K: cli.K, Decompose: cli.Decompose,
+		SVLen: cli.SVLen, MaxSpan: cli.MaxSpan, MinUniq: cli.MinUniq}
+	if opts.MinUniq == 0 {
+		opts.MinUniq = -1 // no filter, rather than the default
+	}
 
 	runtime.GOMAXPROCS(cli.Threads)
 
@@ -312,5 +769,13 @@ func Main() {
 	Check(err)
 
 	out, err := os.Create(outpath)
-	GetVariants(varpath, refpath, cli.K, out)
+	Check(err)
+	defer out.Close()
+	GetVariants(varpath, refpath, opts, out)
+
+	if cli.IDMap != "" {
+		mappath, err := filepath.Abs(cli.IDMap)
+		Check(err)
+		WriteIDMap(outpath, mappath)
+	}
 }
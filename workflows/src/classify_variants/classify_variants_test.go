@@ -2,16 +2,20 @@ package classify_variants_test
 
 import (
 	// "fmt"
+	"bufio"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
+	"AnVir/seqmer"
 	"annotation/classify_variants"
+	"annotation/fastaseq"
 	. "annotation/utils"
+	"annotation/vcf"
 )
 
 func compare_strings(correct string, result string, t *testing.T) {
@@ -67,73 +71,40 @@ func TestClassifyVariant(t *testing.T) {
 	path, _ := filepath.Abs("test_data/out.vcf")
 	out, err := os.Create(path)
 	Check(err)
-	classify_variants.GetVariants(test_variants, test_fasta, 5, out)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 5}, out)
+	out.Close()
+	records := readRecords(path, t)
 
-	/// Simple variants -----------------------------------------
 	t.Run("SNP@6-6", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"1\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "6", "1", "T", "t", ".", ".",
-			"VARTYPE=SNP;END=6;COUNT=1;KMERS=ATCGA,TCGAt,CGAtA,GAtAT,AtATG,tATGG,ATGGC",
-		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
-		}
+		compare_record(records["1"], []string{"contig", "6", "1", "T", "t", ".", "."},
+			map[string]string{"VARTYPE": "SNP", "END": "6", "COUNT": "1",
+				"KMERS": "ATCGA,TCGAt,CGAtA,GAtAT,AtATG,tATGG,ATGGC",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
 	})
-	t.Run("DEL:len=1@18-18", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"2\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "18", "2", "T", "DEL", ".", ".",
-			"VARTYPE=DEL;END=18;COUNT=33;KMERS=CGCAT,GCATT,CATTA,ATTAG,TTAGA,TAGAT",
-		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
-		}
+	t.Run("DEL:len=1@17-18", func(t *testing.T) {
+		compare_record(records["2"], []string{"contig", "17", "2", "TT", "T", ".", "."},
+			map[string]string{"VARTYPE": "DEL", "END": "18", "COUNT": "33",
+				"KMERS": "CGCAT,GCATT,CATTA,ATTAG,TTAGA,TAGAT",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
 	})
-	t.Run("DEL:len=6@22-27", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"3\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "22", "3", "ATTCGA", "DEL", ".", ".",
-			"VARTYPE=DEL;END=27;COUNT=42;KMERS=TTTAG,TTAGT,TAGTC,AGTCG,GTCGG,TCGGG",
-		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
-		}
+	t.Run("DEL:len=6@21-27", func(t *testing.T) {
+		compare_record(records["3"], []string{"contig", "21", "3", "GATTCGA", "G", ".", "."},
+			map[string]string{"VARTYPE": "DEL", "END": "27", "COUNT": "42",
+				"KMERS": "TTTAG,TTAGT,TAGTC,AGTCG,GTCGG,TCGGG",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
 	})
-	t.Run("INS@len=1:12-13", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"4\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "12", "4", "INS", "a", ".", ".",
-			"VARTYPE=INS;END=13;COUNT=11;KMERS=TGGCG,GGCGa,GCGaC,CGaCG,GaCGC,aCGCA,CGCAT",
-		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
-		}
+	t.Run("INS@len=1:12-12", func(t *testing.T) {
+		compare_record(records["4"], []string{"contig", "12", "4", "G", "Ga", ".", "."},
+			map[string]string{"VARTYPE": "INS", "END": "12", "COUNT": "11",
+				"KMERS": "TGGCG,GGCGa,GCGaC,CGaCG,GaCGC,aCGCA,CGCAT",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
 	})
-	t.Run("INS@len=3:17-18", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"5\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "17", "5", "INS", "abc", ".", ".",
-			"VARTYPE=INS;END=18;COUNT=13;KMERS=CGCAT,GCATa,CATab,ATabc,TabcT,abcTT,bcTTA,cTTAG,TTAGA",
-		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
-		}
+	t.Run("INS@len=3:17-17", func(t *testing.T) {
+		compare_record(records["5"], []string{"contig", "17", "5", "T", "Tabc", ".", "."},
+			map[string]string{"VARTYPE": "INS", "END": "17", "COUNT": "13",
+				"KMERS": "CGCAT,GCATa,CATab,ATabc,TabcT,abcTT,bcTTA,cTTAG,TTAGA",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
 	})
 
 
@@ -143,49 +114,381 @@ func TestClassifyVariant(t *testing.T) {
 	// there could be multiple ways to align. I'm just testing the
 	// basic functionality of this component, but it'll give the
 	// right alignment most of the time in regions that aren't too complex.
-	t.Run("COMPOUND-ADJ-SNPs@:7-10", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"6\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "7", "6", "TG", "tg", ".", ".",
-			"VARTYPE=COMPOUND;END=10;COUNT=55;KMERS=CGATA,GATAt,ATAtg,TAtgG,AtgGC,tgGCG,gGCGC,GCGCG",
+	t.Run("COMPOUND-ADJ-SNPs@:8-9", func(t *testing.T) {
+		compare_record(records["6"], []string{"contig", "8", "6", "TG", "tg", ".", "."},
+			map[string]string{"VARTYPE": "COMPOUND", "END": "9", "COUNT": "55",
+				"KMERS": "CGATA,GATAt,ATAtg,TAtgG,AtgGC,tgGCG,gGCGC,GCGCG",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
+	})
+	t.Run("COMPOUND-SNP-DEL@:8-9", func(t *testing.T) {
+		compare_record(records["7"], []string{"contig", "8", "7", "TG", "t", ".", "."},
+			map[string]string{"VARTYPE": "COMPOUND", "END": "9", "COUNT": "100",
+				"KMERS": "CGATA,GATAt,ATAtG,TAtGC,AtGCG,tGCGC,GCGCG",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
+	})
+	t.Run("COMPOUND-SNP-INS@:8-8", func(t *testing.T) {
+		compare_record(records["8"], []string{"contig", "8", "8", "T", "tg", ".", "."},
+			map[string]string{"VARTYPE": "COMPOUND", "END": "8", "COUNT": "1001",
+				"KMERS": "CGATA,GATAt,ATAtg,TAtgG,AtgGG,tgGGC,gGGCG,GGCGC",
+				"UNIQ": "1,1", "MAPQ": "60"}, t)
+	})
+	// TODO add test where len(merged_deviants) < k
+
+	// the stable IDs, from the alleles and from the kmers
+	t.Run("VID and KVID", func(t *testing.T) {
+		ref := fastaseq.LoadContiguousReference(test_fasta)
+		for id := range records {
+			for _, r := range records[id] {
+				end, _ := strconv.Atoi(r.Info["END"][0])
+				compare_strings(classify_variants.VariantKey(r.Chrom, r.Pos,
+					r.Ref, r.Alt, end, ref), r.Info["VID"][0], t)
+				kmers := r.Info["KMERS"]
+				n := len(kmers)
+				compare_strings(seqmer.KmerVariantKey(kmers[0], kmers[1:n-1],
+					kmers[n-1]), r.Info["KVID"][0], t)
+			}
+		}
+	})
+
+	// none of these reach the default sv length
+	for id := range records {
+		for _, r := range records[id] {
+			if _, ok := r.Info["SVTYPE"]; ok {
+				t.Errorf("ID %s is a structural variant", id)
+			}
+		}
+	}
+}
+
+// check the columns (CHROM to FILTER) of the only record of an ID, and the
+// INFO fields in info, values comma separated; other INFO fields have their
+// own tests
+func compare_record(records []*vcf.Record, columns []string,
+		info map[string]string, t *testing.T) {
+	t.Helper()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, found %d", len(records))
+	}
+	r := records[0]
+	result := []string{r.Chrom, strconv.Itoa(r.Pos), r.ID, r.Ref, r.Alt, r.Qual, r.Filter}
+	if !reflect.DeepEqual(result, columns) {
+		t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", columns, result)
+	}
+	for key, correct := range info {
+		compare_strings(correct, strings.Join(r.Info[key], ","), t)
+	}
+}
+
+// read the records of a vcf keyed by ID, without relying on bcftools
+func readRecords(path string, t *testing.T) map[string][]*vcf.Record {
+	f, err := os.Open(path)
+	Check(err)
+	defer f.Close()
+	records := make(map[string][]*vcf.Record)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line[0] != '#' {
+			record, err := vcf.ParseVCFRecord(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records[record.ID] = append(records[record.ID], record)
+		}
+	}
+	return records
+}
+
+// check the POS/REF/ALT/VARTYPE of a record
+func compare_alleles(record *vcf.Record, correct []string, t *testing.T) {
+	result := []string{strconv.Itoa(record.Pos), record.Ref, record.Alt,
+		record.Info["VARTYPE"][0]}
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
+	}
+}
+
+// Every record must have spec compliant alleles: no placeholders or gaps,
+// and indels padded with the preceding reference base.
+func TestVcfAlleles(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref.fa")
+	test_variants, _ := filepath.Abs("test_data/test_variants.tsv")
+	path, _ := filepath.Abs("test_data/out.vcf")
+	out, err := os.Create(path)
+	Check(err)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 5}, out)
+	out.Close()
+	records := readRecords(path, t)
+
+	correct := map[string][]string{
+		"1": {"6", "T", "t", "SNP"},
+		"2": {"17", "TT", "T", "DEL"},
+		"3": {"21", "GATTCGA", "G", "DEL"},
+		"4": {"12", "G", "Ga", "INS"},
+		"5": {"17", "T", "Tabc", "INS"},
+		"6": {"8", "TG", "tg", "COMPOUND"},
+		"7": {"8", "TG", "t", "COMPOUND"},
+		"8": {"8", "T", "tg", "COMPOUND"},
+	}
+	for id, c := range correct {
+		t.Run("ID="+id, func(t *testing.T) {
+			if len(records[id]) != 1 {
+				t.Fatalf("expected 1 record for ID %s, found %d", id, len(records[id]))
+			}
+			compare_alleles(records[id][0], c, t)
+		})
+	}
+	for id := range records {
+		for _, r := range records[id] {
+			if strings.ContainsAny(r.Ref+r.Alt, "-") || r.Ref == "INS" || r.Alt == "DEL" {
+				t.Errorf("ID %s has non vcf alleles %s/%s", id, r.Ref, r.Alt)
+			}
+		}
+	}
+}
+
+// Compound variants split into atomic records with --decompose.
+func TestDecompose(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref.fa")
+	test_variants, _ := filepath.Abs("test_data/test_variants.tsv")
+	path, _ := filepath.Abs("test_data/out_decomposed.vcf")
+	out, err := os.Create(path)
+	Check(err)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 5, Decompose: true}, out)
+	out.Close()
+	defer os.Remove(path)
+	records := readRecords(path, t)
+
+	t.Run("COMPOUND-ADJ-SNPs", func(t *testing.T) {
+		if len(records["6"]) != 2 {
+			t.Fatalf("expected 2 records, found %d", len(records["6"]))
 		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
+		compare_alleles(records["6"][0], []string{"8", "T", "t", "SNP"}, t)
+		compare_alleles(records["6"][1], []string{"9", "G", "g", "SNP"}, t)
+	})
+	t.Run("alignment", func(t *testing.T) {
+		// ref: ATCGATATG-GCGCGCAT
+		//             TG-GC
+		//             tGaG-
+		ref := fastaseq.LoadContiguousReference(test_fasta)
+		variants := classify_variants.DecomposeAlignment("x", "1", 8,
+			"TG-GC", "tGaG-", ref)
+		if len(variants) != 3 {
+			t.Fatalf("expected 3 variants, found %d", len(variants))
 		}
+		compare_alleles(variants[0].Record(ref.Contig), []string{"8", "T", "t", "SNP"}, t)
+		compare_alleles(variants[1].Record(ref.Contig), []string{"9", "G", "Ga", "INS"}, t)
+		compare_alleles(variants[2].Record(ref.Contig), []string{"10", "GC", "G", "DEL"}, t)
 	})
-	t.Run("COMPOUND-SNP-DEL@:7-10", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"7\"", "-H", path).CombinedOutput()
+}
+
+// Large events become symbolic structural variants, and events spanning
+// more than MaxSpan are dropped as spurious anchor mappings.
+func TestStructuralVariants(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref.fa")
+	test_variants, _ := filepath.Abs("test_data/test_variants.tsv")
+	path, _ := filepath.Abs("test_data/out_sv.vcf")
+	defer os.Remove(path)
+
+	classify := func(opts classify_variants.Options) map[string][]*vcf.Record {
+		out, err := os.Create(path)
 		Check(err)
-		correct := []string{
-			"contig", "7", "7", "TG", "t-", ".", ".",
-			"VARTYPE=COMPOUND;END=10;COUNT=100;KMERS=CGATA,GATAt,ATAtG,TAtGC,AtGCG,tGCGC,GCGCG",
+		classify_variants.GetVariants(test_variants, test_fasta, opts, out)
+		out.Close()
+		return readRecords(path, t)
+	}
+
+	t.Run("symbolic DEL", func(t *testing.T) {
+		records := classify(classify_variants.Options{K: 5, SVLen: 5})
+		if len(records["3"]) != 1 {
+			t.Fatalf("expected 1 record, found %d", len(records["3"]))
+		}
+		r := records["3"][0]
+		compare_alleles(r, []string{"21", "G", "<DEL>", "DEL"}, t)
+		compare_strings("-6", r.Info["SVLEN"][0], t)
+		compare_strings("27", r.Info["END"][0], t)
+		compare_strings(".", r.Filter, t)
+		compare_strings("1", r.Info["UNIQ"][0], t)
+		// small variants are unchanged
+		compare_alleles(records["2"][0], []string{"17", "TT", "T", "DEL"}, t)
+	})
+	t.Run("replacement", func(t *testing.T) {
+		// TG at 8-9 replaced by t, and by tg: the span is deleted and the
+		// novel bases inserted after the base before the event
+		records := classify(classify_variants.Options{K: 5, SVLen: 2})
+		if len(records["7"]) != 2 || len(records["6"]) != 2 {
+			t.Fatalf("expected 2 records each, found %d and %d",
+				len(records["7"]), len(records["6"]))
 		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
+		compare_alleles(records["7"][0], []string{"7", "A", "<DEL>", "DEL"}, t)
+		compare_strings("-2", records["7"][0].Info["SVLEN"][0], t)
+		compare_strings("9", records["7"][0].Info["END"][0], t)
+		compare_alleles(records["7"][1], []string{"7", "A", "At", "INS"}, t)
+		compare_alleles(records["6"][1], []string{"7", "A", "<INS>", "INS"}, t)
+		compare_strings("2", records["6"][1].Info["SVLEN"][0], t)
+		compare_strings("7", records["6"][1].Info["END"][0], t)
+		// T at 8 replaced by tg: only the insertion is large
+		if len(records["8"]) != 2 {
+			t.Fatalf("expected 2 records, found %d", len(records["8"]))
 		}
+		compare_alleles(records["8"][0], []string{"7", "AT", "A", "DEL"}, t)
+		if _, ok := records["8"][0].Info["SVLEN"]; ok {
+			t.Errorf("expected a padded deletion")
+		}
+		compare_alleles(records["8"][1], []string{"7", "A", "<INS>", "INS"}, t)
 	})
-	t.Run("COMPOUND-SNP-INS@:7-10", func(t *testing.T) {
-		out, _ := exec.Command(
-			"bcftools", "view", "-i", "ID=\"8\"", "-H", path).CombinedOutput()
-		Check(err)
-		correct := []string{
-			"contig", "7", "8", "T-", "tg", ".", ".",
-			"VARTYPE=COMPOUND;END=9;COUNT=1001;KMERS=CGATA,GATAt,ATAtg,TAtgG,AtgGG,tgGGC,gGGCG,GGCGC",
+	t.Run("min uniqueness", func(t *testing.T) {
+		records := classify(classify_variants.Options{K: 5, SVLen: 5, MinUniq: 2})
+		compare_strings("MULTIMAP", records["3"][0].Filter, t)
+		records = classify(classify_variants.Options{K: 5, SVLen: 5, MinUniq: -1})
+		compare_strings(".", records["3"][0].Filter, t) // no filter
+	})
+	t.Run("max span", func(t *testing.T) {
+		records := classify(classify_variants.Options{K: 5, MaxSpan: 5})
+		if len(records["3"]) != 0 {
+			t.Errorf("expected the 6 base deletion to be dropped")
 		}
-		result := strings.Fields(string(out))
-		if !reflect.DeepEqual(result, correct) {
-			t.Errorf("\nCORRECT:\n%s\nRESULT\n%s", correct, result)
+		if len(records["2"]) != 1 {
+			t.Errorf("expected the 1 base deletion to be kept")
 		}
 	})
-	// TODO add test where len(merged_deviants) < k
+}
 
+// The next anchor (AAGTT) occurs twice in test_ref_repeat.fa, only the
+// placement next to the prev anchor explains the chain as a single SNP.
+func TestRepetitiveAnchors(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref_repeat.fa")
+	test_variants, _ := filepath.Abs("test_data/test_variants_repeat.tsv")
+	path, _ := filepath.Abs("test_data/out_repeat.vcf")
+	defer os.Remove(path)
 
+	out, err := os.Create(path)
+	Check(err)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 5}, out)
+	out.Close()
+	records := readRecords(path, t)
 
+	if len(records["1"]) != 1 {
+		t.Fatalf("expected 1 record, found %d", len(records["1"]))
+	}
+	r := records["1"][0]
+	compare_alleles(r, []string{"14", "C", "t", "SNP"}, t)
+	compare_strings("1", r.Info["UNIQ"][0], t)
+	compare_strings("0.5", r.Info["UNIQ"][1], t)
+	compare_strings("60", r.Info["MAPQ"][0], t)
+}
+
+// Variants with one anchor ("-" for the other), at the ends of sequences,
+// become single breakends.
+func TestOpenEnded(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref.fa")
+	dir := t.TempDir()
+	test_variants := filepath.Join(dir, "open_variants.tsv")
+	Check(os.WriteFile(test_variants, []byte("header 1\nheader 2\n"+
+		"VariantID\torigID\tcount\tname\tVID\tdevnum\tprev\tdeviants\tnext\n"+
+		"1\t1\t3\t\tx\t2\tATCGA\tTCGAt\tCGAtA\t-\t\n"+
+		"2\t2\t4\t\tx\t1\t-\taGCAT\tGCATT\t\n"+
+		"3\t3\t5\t\tx\t1\t-\taGCAT\t-\t\n"), 0644))
+	path := filepath.Join(dir, "open.vcf")
+	out, err := os.Create(path)
+	Check(err)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 5}, out)
+	out.Close()
+	records := readRecords(path, t)
+
+	t.Run("open end", func(t *testing.T) {
+		r := records["1"][0]
+		compare_alleles(r, []string{"5", "A", "AtA.", "OPEN"}, t)
+		compare_strings("end", r.Info["OPEN"][0], t)
+		compare_strings("1,.", strings.Join(r.Info["UNIQ"], ","), t)
+	})
+	t.Run("open start", func(t *testing.T) {
+		r := records["2"][0]
+		compare_alleles(r, []string{"14", "G", ".aG", "OPEN"}, t)
+		compare_strings("start", r.Info["OPEN"][0], t)
+		compare_strings(seqmer.KmerVariantKey("", []string{"aGCAT"}, "GCATT"), r.Info["KVID"][0], t)
+	})
+	t.Run("no anchor", func(t *testing.T) {
+		if len(records["3"]) != 0 {
+			t.Errorf("expected no records, found %d", len(records["3"]))
+		}
+	})
+}
+
+// The same mutation gets the same VID however it is written, and each record
+// keeps its table ID alongside.
+func TestStableIDs(t *testing.T) {
+	test_fasta, _ := filepath.Abs("test_data/test_ref.fa")
+	test_variants, _ := filepath.Abs("test_data/test_variants.tsv")
+	ref := fastaseq.LoadContiguousReference(test_fasta)
+
+	t.Run("normalize", func(t *testing.T) {
+		// ref: ATCGATATGGCGCGCAT
+		for _, c := range [][]string{
+			{"14", "G", "GCG", "9", "G", "GGC"},
+			{"12", "G", "GCG", "9", "G", "GGC"},
+			{"17", "TT", "T", "16", "AT", "A"},
+			{"8", "TGG", "tcg", "9", "G", "C"},
+			{"7", "atg", "AcG", "8", "T", "C"},
+			{"8", "TGG", "TCG", "9", "G", "C"},
+		} {
+			pos, _ := strconv.Atoi(c[0])
+			pos, ref_allele, alt_allele := classify_variants.NormalizeAlleles(pos, c[1], c[2], ref)
+			compare_strings(strings.Join(c[3:], " "),
+				strings.Join([]string{strconv.Itoa(pos), ref_allele, alt_allele}, " "), t)
+		}
+	})
+	t.Run("VID", func(t *testing.T) {
+		vid := classify_variants.VariantKey("contig", 14, "G", "GCG", 14, ref)
+		compare_strings(vid, classify_variants.VariantKey("contig", 12, "G", "GCG", 12, ref), t)
+		if vid == classify_variants.VariantKey("other", 14, "G", "GCG", 14, ref) {
+			t.Error("the VID should depend on the contig")
+		}
+		if classify_variants.VariantKey("contig", 5, "A", "<DEL>", 10, ref) ==
+			classify_variants.VariantKey("contig", 5, "A", "<DEL>", 11, ref) {
+			t.Error("symbolic alleles should be told apart by END")
+		}
+		compare_strings(classify_variants.VariantKey("contig", 8, "TGG", "TCG", 8, ref),
+			classify_variants.VariantKey("contig", 8, "tgg", "tcg", 8, ref), t)
+		compare_strings("16", strconv.Itoa(len(vid)), t)
+	})
+	t.Run("records and ID map", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.vcf")
+		out, err := os.Create(path)
+		Check(err)
+		classify_variants.GetVariants(test_variants, test_fasta,
+			classify_variants.Options{K: 5}, out)
+		out.Close()
+		records := readRecords(path, t)
+		r := records["1"][0]
+		compare_strings(classify_variants.VariantKey(r.Chrom, r.Pos, r.Ref, r.Alt, r.Pos, ref),
+			r.Info["VID"][0], t)
+		kmers := r.Info["KMERS"]
+		compare_strings(seqmer.KmerVariantKey(kmers[0], kmers[1:len(kmers)-1], kmers[len(kmers)-1]),
+			r.Info["KVID"][0], t)
+
+		mappath := filepath.Join(t.TempDir(), "idmap.tsv")
+		classify_variants.WriteIDMap(path, mappath)
+		data, err := os.ReadFile(mappath)
+		Check(err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		compare_strings("ID\tVID\tKVID\tCHROM\tPOS\tREF\tALT", lines[0], t)
+		found := false
+		for _, line := range lines[1:] {
+			fields := strings.Split(line, "\t")
+			if fields[0] == "1" {
+				found = true
+				compare_strings(r.Info["VID"][0], fields[1], t)
+				compare_strings(r.Info["KVID"][0], fields[2], t)
+			}
+		}
+		if !found {
+			t.Error("ID 1 missing from the ID map")
+		}
+	})
 }
 
 // ============================================================================
@@ -201,6 +504,7 @@ func BenchmarkVariantClassification(b *testing.B) {
 	defer out.Close()
 	Check(err)
 	runtime.GOMAXPROCS(8)
-	classify_variants.GetVariants(test_variants, test_fasta, 14, out)
+	classify_variants.GetVariants(test_variants, test_fasta,
+		classify_variants.Options{K: 14}, out)
 }
 
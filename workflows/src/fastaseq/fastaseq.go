@@ -45,6 +45,20 @@ func (fs *WindowedReference)Query(seq string) []Interval {
 	return fs.Kmer2coords[seq]
 }
 
+// Number of times the kmer occurs in the reference, 0 if it doesn't.
+func (fs *WindowedReference)Multiplicity(seq string) int {
+	return len(fs.Kmer2coords[seq])
+}
+
+// Fraction of the reference hits of the kmer that any one placement
+// accounts for (1/multiplicity), 0 for kmers not in the reference.
+func (fs *WindowedReference)Uniqueness(seq string) float64 {
+	if m := fs.Multiplicity(seq); m > 0 {
+		return 1.0 / float64(m)
+	}
+	return 0
+}
+
 // Load reference genome (single fasta record) for kmer window queries.
 // Serves as the constructor for WindowedReference objects.
 func LoadWindowedReference(fasta_path string, k int) *WindowedReference {
@@ -44,6 +44,20 @@ func TestWindowedReference(t *testing.T)() {
 		result = Ref.Query("AAT")
 		correct = []Interval{{5, 7}, {11, 13}}
 	})
+	t.Run("Multiplicity", func(t *testing.T)() {
+		for seq, correct := range map[string]int{"ATC": 1, "GAA": 2, "CCC": 0} {
+			if result := Ref.Multiplicity(seq); result != correct {
+				t.Errorf("Multiplicity(%s): correct = %d; result = %d",
+					seq, correct, result)
+			}
+		}
+		if result := Ref.Uniqueness("GAA"); result != 0.5 {
+			t.Errorf("Uniqueness(GAA): correct = 0.5; result = %v", result)
+		}
+		if result := Ref.Uniqueness("CCC"); result != 0 {
+			t.Errorf("Uniqueness(CCC): correct = 0; result = %v", result)
+		}
+	})
 }
 
 func BenchmarkWindowedReference(b *testing.B) {
@@ -0,0 +1,354 @@
+// Time resolved variant and haplotype frequencies from the time and place
+// breakdowns written by haploscan and tagvars (varmetafile, hapmetafile).
+package frequencies
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+
+	. "annotation/utils"
+)
+
+type cliargs struct {
+	Breakdown string `arg:"--breakdown,required,help:variant or haplotype breakdown by time and place as written by haploscan or tagvars."`
+	Outfile   string `arg:"--outfile,required,help:Output tidy table of counts and frequencies."`
+	Bin       string `arg:"--bin,help:day/week/month (default week)."`
+	By        string `arg:"--by,help:also split by region/country/division."`
+	Growth    string `arg:"--growth,help:Output logistic growth fits with 95% confidence intervals."`
+}
+
+func (c cliargs) Description() string {
+	return "Variant or haplotype counts and frequencies binned by collection date, with logistic growth fits."
+}
+
+// ============================================================================
+/// Structs/Related functions
+// ============================================================================
+
+// one line of a breakdown: count sequences of ID collected on date at place
+type entry struct {
+	id    string
+	date  string
+	place map[string]string // region, country, division
+	count int
+}
+
+// Breakdown holds the counts of each ID, and of all sequences (ID "all"),
+// by collection date and place.
+type Breakdown struct {
+	entries []entry
+	totals  []entry
+}
+
+// Frequency is one tidy output row: count of ID among total sequences in a
+// date bin (and place).
+type Frequency struct {
+	ID        string
+	Bin       string // start date of the bin
+	Place     string // "all" unless split by place
+	Count     int
+	Total     int
+	Frequency float64
+}
+
+// Fit is a logistic growth fit of the frequency of ID over time, logit(p) =
+// a + Rate*days.  Advantage is the relative growth per week, exp(7 Rate),
+// with its 95% confidence interval.
+type Fit struct {
+	ID                 string
+	Place              string
+	Bins               int
+	Rate               float64 // per day
+	SE                 float64
+	Lower, Upper       float64 // 95% confidence interval of the rate
+	Advantage          float64
+	AdvLower, AdvUpper float64
+}
+
+// ============================================================================
+/// Reading
+// ============================================================================
+
+// Read a breakdown table.  The ID is the first column, and the date, region,
+// country, division and count columns are found by name.
+func ReadBreakdown(path string) (*Breakdown, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &Breakdown{}
+	columns := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if line == 0 {
+			for i, name := range fields {
+				columns[name] = i
+			}
+			for _, name := range []string{"date", "region", "country", "division", "count"} {
+				if _, ok := columns[name]; !ok {
+					return nil, fmt.Errorf("%s: no %s column", path, name)
+				}
+			}
+			continue
+		}
+		count, err := strconv.Atoi(fields[columns["count"]])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line+1, err)
+		}
+		e := entry{id: fields[0], date: fields[columns["date"]], count: count, place: make(map[string]string)}
+		for _, name := range []string{"region", "country", "division"} {
+			e.place[name] = fields[columns[name]]
+		}
+		if e.id == "all" {
+			b.totals = append(b.totals, e)
+		} else {
+			b.entries = append(b.entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.totals) == 0 {
+		return nil, fmt.Errorf("%s: no sequence totals (ID all), rerun haploscan or tagvars to write them", path)
+	}
+	return b, nil
+}
+
+// ============================================================================
+/// Binning
+// ============================================================================
+
+// the start date of the bin holding date: the day, the Monday of its week, or
+// the first of its month.  Dates less precise than the bin are not binned.
+func binOf(date string, bin string) (time.Time, bool) {
+	switch bin {
+	case "day", "week":
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if bin == "week" {
+			day = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		}
+		return day, true
+	case "month":
+		if len(date) < 7 {
+			return time.Time{}, false
+		}
+		month, err := time.Parse("2006-01", date[:7])
+		return month, err == nil
+	}
+	return time.Time{}, false
+}
+
+// Frequencies bins the breakdown by collection date (day, week or month),
+// optionally splitting by a place field (region, country or division).
+// Sequences without a date precise enough for the bin are left out.
+func (b *Breakdown) Frequencies(bin string, by string) ([]Frequency, error) {
+	if bin != "day" && bin != "week" && bin != "month" {
+		return nil, fmt.Errorf("unknown bin %q, expected day, week or month", bin)
+	}
+	if by != "" && by != "region" && by != "country" && by != "division" {
+		return nil, fmt.Errorf("unknown place %q, expected region, country or division", by)
+	}
+	type key struct{ id, bin, place string }
+	keyOf := func(e entry) (key, bool) {
+		start, ok := binOf(e.date, bin)
+		place := "all"
+		if by != "" {
+			place = e.place[by]
+		}
+		return key{e.id, start.Format("2006-01-02"), place}, ok
+	}
+
+	totals := make(map[key]int)
+	for _, e := range b.totals {
+		if k, ok := keyOf(e); ok {
+			k.id = ""
+			totals[k] += e.count
+		}
+	}
+	counts := make(map[key]int)
+	for _, e := range b.entries {
+		if k, ok := keyOf(e); ok {
+			counts[k] += e.count
+		}
+	}
+
+	// every ID gets a row for every bin and place with sequences, zeros included
+	ids := make(map[string]bool)
+	for k := range counts {
+		ids[k.id] = true
+	}
+	rows := make([]Frequency, 0, len(ids)*len(totals))
+	for id := range ids {
+		for k, total := range totals {
+			k.id = id
+			rows = append(rows, Frequency{id, k.bin, k.place, counts[k], total, float64(counts[k]) / float64(total)})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].ID != rows[j].ID {
+			return idLess(rows[i].ID, rows[j].ID)
+		}
+		if rows[i].Place != rows[j].Place {
+			return rows[i].Place < rows[j].Place
+		}
+		return rows[i].Bin < rows[j].Bin
+	})
+	return rows, nil
+}
+
+// numeric IDs in numeric order
+func idLess(a string, b string) bool {
+	x, errx := strconv.Atoi(a)
+	y, erry := strconv.Atoi(b)
+	if errx == nil && erry == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// ============================================================================
+/// Logistic growth
+// ============================================================================
+
+// Growth fits logit(frequency) = a + rate*days to the binned counts of each ID
+// and place by binomial maximum likelihood.  Fits that don't converge, eg a
+// frequency of 0 or 1 throughout, or with fewer than two bins, are left out.
+func Growth(rows []Frequency) []Fit {
+	type series struct{ id, place string }
+	grouped := make(map[series][]Frequency)
+	order := make([]series, 0)
+	for _, row := range rows {
+		s := series{row.ID, row.Place}
+		if _, ok := grouped[s]; !ok {
+			order = append(order, s)
+		}
+		grouped[s] = append(grouped[s], row)
+	}
+
+	fits := make([]Fit, 0, len(order))
+	for _, s := range order {
+		if fit, ok := logistic(grouped[s]); ok {
+			fit.ID, fit.Place = s.id, s.place
+			fits = append(fits, fit)
+		}
+	}
+	return fits
+}
+
+// fit the logistic regression by Newton-Raphson
+func logistic(rows []Frequency) (Fit, bool) {
+	if len(rows) < 2 {
+		return Fit{}, false
+	}
+	first, _ := time.Parse("2006-01-02", rows[0].Bin)
+	days := make([]float64, len(rows))
+	for i, row := range rows {
+		start, _ := time.Parse("2006-01-02", row.Bin)
+		days[i] = start.Sub(first).Hours() / 24
+	}
+
+	var a, rate float64
+	var info [2][2]float64 // Fisher information
+	for iteration := 0; iteration < 50; iteration++ {
+		var score [2]float64
+		info = [2][2]float64{}
+		for i, row := range rows {
+			p := 1 / (1 + math.Exp(-(a + rate*days[i])))
+			n := float64(row.Total)
+			residual := float64(row.Count) - n*p
+			weight := n * p * (1 - p)
+			score[0] += residual
+			score[1] += residual * days[i]
+			info[0][0] += weight
+			info[0][1] += weight * days[i]
+			info[1][1] += weight * days[i] * days[i]
+		}
+		det := info[0][0]*info[1][1] - info[0][1]*info[0][1]
+		if det <= 0 || math.IsNaN(det) {
+			return Fit{}, false
+		}
+		stepa := (info[1][1]*score[0] - info[0][1]*score[1]) / det
+		steprate := (info[0][0]*score[1] - info[0][1]*score[0]) / det
+		a += stepa
+		rate += steprate
+		if math.Abs(stepa) < 1e-10 && math.Abs(steprate) < 1e-12 {
+			se := math.Sqrt(info[0][0] / det)
+			fit := Fit{Bins: len(rows), Rate: rate, SE: se, Lower: rate - 1.96*se, Upper: rate + 1.96*se}
+			fit.Advantage = math.Exp(7 * fit.Rate)
+			fit.AdvLower, fit.AdvUpper = math.Exp(7*fit.Lower), math.Exp(7*fit.Upper)
+			return fit, true
+		}
+	}
+	return Fit{}, false
+}
+
+// ============================================================================
+/// Output
+// ============================================================================
+
+func WriteFrequencies(path string, rows []Frequency) {
+	f, err := os.Create(path)
+	Check(err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	fmt.Fprintln(w, "ID\tbin\tplace\tcount\ttotal\tfrequency")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.6f\n", row.ID, row.Bin, row.Place, row.Count, row.Total, row.Frequency)
+	}
+}
+
+func WriteGrowth(path string, fits []Fit) {
+	f, err := os.Create(path)
+	Check(err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	fmt.Fprintln(w, "ID\tplace\tbins\trate\tse\trate_lower\trate_upper\tweekly_advantage\tadvantage_lower\tadvantage_upper")
+	for _, fit := range fits {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.6g\t%.6g\t%.6g\t%.6g\t%.4f\t%.4f\t%.4f\n", fit.ID, fit.Place, fit.Bins,
+			fit.Rate, fit.SE, fit.Lower, fit.Upper, fit.Advantage, fit.AdvLower, fit.AdvUpper)
+	}
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+	if cli.Bin == "" {
+		cli.Bin = "week"
+	}
+
+	inpath, err := filepath.Abs(cli.Breakdown)
+	Check(err)
+	b, err := ReadBreakdown(inpath)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	rows, err := b.Frequencies(cli.Bin, cli.By)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	outpath, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	WriteFrequencies(outpath, rows)
+	if cli.Growth != "" {
+		growthpath, err := filepath.Abs(cli.Growth)
+		Check(err)
+		WriteGrowth(growthpath, Growth(rows))
+	}
+}
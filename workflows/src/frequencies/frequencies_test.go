@@ -0,0 +1,56 @@
+package frequencies_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"annotation/frequencies"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestFrequencies(t *testing.T) {
+	path, _ := filepath.Abs("test_data/breakdown.xls")
+	b, err := frequencies.ReadBreakdown(path)
+	Check(err)
+
+	t.Run("weekly", func(t *testing.T) {
+		// the month-only dated sequences are left out of weeks
+		rows, err := b.Frequencies("week", "")
+		Check(err)
+		compare(len(rows), 8, t)
+		compare(rows[0], frequencies.Frequency{"1", "2021-10-04", "all", 10, 100, 0.1}, t)
+		compare(rows[3], frequencies.Frequency{"1", "2021-10-25", "all", 60, 100, 0.6}, t)
+	})
+	t.Run("monthly by country", func(t *testing.T) {
+		rows, err := b.Frequencies("month", "country")
+		Check(err)
+		compare(len(rows), 4, t)
+		compare(rows[3], frequencies.Frequency{"2", "2021-10-01", "United Kingdom", 125, 245, 125.0 / 245}, t)
+	})
+	t.Run("unknown bin", func(t *testing.T) {
+		if _, err := b.Frequencies("year", ""); err == nil {
+			t.Error("expected an error for bin year")
+		}
+	})
+	t.Run("growth", func(t *testing.T) {
+		rows, err := b.Frequencies("week", "")
+		Check(err)
+		fits := frequencies.Growth(rows)
+		compare(len(fits), 2, t)
+		// variant 1 grows, its confidence interval well above no growth
+		if fits[0].ID != "1" || fits[0].Lower <= 0 || fits[0].AdvLower <= 1 {
+			t.Errorf("expected growth of variant 1, got %+v", fits[0])
+		}
+		// variant 2 holds at half the sequences
+		if fits[1].ID != "2" || fits[1].Lower >= 0 || fits[1].Upper <= 0 {
+			t.Errorf("expected no growth of variant 2, got %+v", fits[1])
+		}
+	})
+}
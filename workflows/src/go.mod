@@ -3,6 +3,7 @@ module annotation
 go 1.18
 
 require (
+	AnVir v0.0.0
 	github.com/alexflint/go-arg v1.4.3 // indirect
 	github.com/alexflint/go-scalar v1.1.0 // indirect
 	github.com/biogo/biogo v1.0.4 // indirect
@@ -11,6 +12,9 @@ require (
 	github.com/biogo/store v0.0.0-20200104231603-2c6ad937eb83 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.1 // indirect
+	github.com/yourbasic/bit v0.0.0-20180313074424-45a4409f4082 // indirect
 	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
 	golang.org/x/tools v0.1.10 // indirect
 )
+
+replace AnVir => ../../go
@@ -0,0 +1,190 @@
+// Tools for working with the .kcounts kmer count files written by seqmer.
+package kmers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+)
+
+type convertargs struct {
+	Infile  string `arg:"--infile,required,help:Input .kcounts (tab separated or binary)."`
+	Outfile string `arg:"--outfile,required,help:Output .kcounts"`
+	To      string `arg:"--to,help:output format (binary or tsv). Defaults to the other format from the input."`
+}
+
+type setargs struct {
+	Infiles  []string `arg:"positional,required,help:Input .kcounts files (tab separated or binary)."`
+	Outfile  string   `arg:"--outfile,required,help:Output .kcounts"`
+	Combine  string   `arg:"--combine,help:how the counts of a kmer found in several files combine: sum (default) or min or max."`
+	MinCount int      `arg:"--min-count,help:drop kmers with a count below this."`
+	MaxCount int      `arg:"--max-count,help:drop kmers with a count above this (0 for no limit)."`
+	Binary   bool     `arg:"--binary,help:write the binary .kcounts format."`
+}
+
+type containsargs struct {
+	Kmers        string `arg:"--kmers,required,help:.kcounts to search."`
+	Query        string `arg:"--query,required,help:.kcounts of shorter query kmers."`
+	Outfile      string `arg:"--outfile,required,help:Output .kcounts of the kmers containing a query kmer."`
+	QueryOutfile string `arg:"--query-outfile,help:Output .kcounts of the query kmers found (counted once per containing kmer)."`
+	Binary       bool   `arg:"--binary,help:write the binary .kcounts format."`
+}
+
+type cliargs struct {
+	Convert   *convertargs  `arg:"subcommand:convert" help:"convert .kcounts between the tab separated and binary formats"`
+	Union     *setargs      `arg:"subcommand:union" help:"kmers in any of the files"`
+	Intersect *setargs      `arg:"subcommand:intersect" help:"kmers in all of the files"`
+	Diff      *setargs      `arg:"subcommand:diff" help:"kmers in the first file and none of the others"`
+	Symdiff   *setargs      `arg:"subcommand:symdiff" help:"kmers in exactly one of the files"`
+	Contains  *containsargs `arg:"subcommand:contains" help:"kmers containing shorter query kmers"`
+}
+func (c cliargs) Description() string {
+	return "Work with .kcounts kmer count files."
+}
+
+// ============================================================================
+/// Convert
+// ============================================================================
+
+// Convert infile to the format named by to ("binary" or "tsv").  An empty to
+// picks whichever format infile is not.
+func Convert(infile string, outfile string, to string) error {
+	if to == "" {
+		if seqmer.IsKbinary(infile) {
+			to = "tsv"
+		} else {
+			to = "binary"
+		}
+	}
+	switch to {
+	case "binary":
+		seqmer.KcountsToBinary(infile, outfile)
+	case "tsv":
+		seqmer.KcountsToTSV(infile, outfile)
+	default:
+		return fmt.Errorf("unknown kcounts format %q, expected binary or tsv", to)
+	}
+	return nil
+}
+
+// ============================================================================
+/// Set algebra
+// ============================================================================
+
+// Combine the kmer sets in infiles with op (union, intersect, diff or
+// symdiff).  combine ("sum", "min" or "max") sets how the counts of a kmer
+// found in several files are combined by union and intersect, "" is sum.
+func SetOp(op string, infiles []string, combine string) (*seqmer.Oligos, error) {
+	if combine == "" {
+		combine = "sum"
+	}
+	combiner := seqmer.Kcombiner(combine)
+	if combiner == nil {
+		return nil, fmt.Errorf("unknown count combination %q, expected sum, min or max", combine)
+	}
+	if len(infiles) == 0 {
+		return nil, fmt.Errorf("no input .kcounts files")
+	}
+	sets := make([]*seqmer.Oligos, 0, len(infiles))
+	for _, infile := range infiles {
+		sets = append(sets, seqmer.ReadKcounts(infile))
+	}
+	switch op {
+	case "union":
+		return seqmer.Kunion(combiner, sets...), nil
+	case "intersect":
+		return seqmer.Kintersect(combiner, sets...), nil
+	case "diff":
+		return seqmer.Kdiff(sets...), nil
+	case "symdiff":
+		return seqmer.Ksymdiff(sets...), nil
+	}
+	return nil, fmt.Errorf("unknown set operation %q", op)
+}
+
+// Write the kmer set to outfile, tab separated or binary.
+func Write(kmers *seqmer.Oligos, outfile string, binary bool) {
+	kmers.Outfile = outfile
+	if binary {
+		kmers.Kbinprint()
+	} else {
+		kmers.Kprint()
+	}
+}
+
+func runConvert(args *convertargs) error {
+	inpath, err := filepath.Abs(args.Infile)
+	Check(err)
+	outpath, err := filepath.Abs(args.Outfile)
+	Check(err)
+	return Convert(inpath, outpath, args.To)
+}
+
+func runSetOp(op string, args *setargs) error {
+	infiles := make([]string, 0, len(args.Infiles))
+	for _, infile := range args.Infiles {
+		inpath, err := filepath.Abs(infile)
+		Check(err)
+		infiles = append(infiles, inpath)
+	}
+	outpath, err := filepath.Abs(args.Outfile)
+	Check(err)
+
+	kmers, err := SetOp(op, infiles, args.Combine)
+	if err != nil {
+		return err
+	}
+	kmers.Kthreshold(args.MinCount, args.MaxCount)
+	Write(kmers, outpath, args.Binary)
+	return nil
+}
+
+func runContains(args *containsargs) {
+	kmerspath, err := filepath.Abs(args.Kmers)
+	Check(err)
+	querypath, err := filepath.Abs(args.Query)
+	Check(err)
+	outpath, err := filepath.Abs(args.Outfile)
+	Check(err)
+
+	kqmers, qkmers := seqmer.Kcontains(
+		seqmer.ReadKcounts(kmerspath), seqmer.ReadKcounts(querypath))
+	Write(kqmers, outpath, args.Binary)
+	if args.QueryOutfile != "" {
+		querypath, err := filepath.Abs(args.QueryOutfile)
+		Check(err)
+		Write(qkmers, querypath, args.Binary)
+	}
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+
+	var err error
+	switch {
+	case cli.Convert != nil:
+		err = runConvert(cli.Convert)
+	case cli.Union != nil:
+		err = runSetOp("union", cli.Union)
+	case cli.Intersect != nil:
+		err = runSetOp("intersect", cli.Intersect)
+	case cli.Diff != nil:
+		err = runSetOp("diff", cli.Diff)
+	case cli.Symdiff != nil:
+		err = runSetOp("symdiff", cli.Symdiff)
+	case cli.Contains != nil:
+		runContains(cli.Contains)
+	default:
+		p.WriteHelp(os.Stdout)
+		os.Exit(1)
+	}
+	if err != nil {
+		p.Fail(err.Error())
+	}
+}
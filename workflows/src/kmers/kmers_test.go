@@ -0,0 +1,136 @@
+package kmers_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"AnVir/seqmer"
+	"annotation/kmers"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+// read a tab separated kcounts file into a map, skipping the header lines
+func readCounts(path string, t *testing.T) map[string]int {
+	f, err := os.Open(path)
+	Check(err)
+	defer f.Close()
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "kmer\t") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			t.Fatalf("bad count in %s: %s", path, line)
+		}
+		counts[fields[0]] = count
+	}
+	return counts
+}
+
+func TestConvert(t *testing.T) {
+	tsv, _ := filepath.Abs("test_data/small.kcounts")
+	bin, _ := filepath.Abs("test_data/small.bin.kcounts")
+	back, _ := filepath.Abs("test_data/small.back.kcounts")
+	defer os.Remove(bin)
+	defer os.Remove(back)
+
+	Check(kmers.Convert(tsv, bin, ""))
+	t.Run("binary header", func(t *testing.T) {
+		if !seqmer.IsKbinary(bin) {
+			t.Fatalf("%s is not binary", bin)
+		}
+		kx := seqmer.ReadKIndex(bin)
+		compare(kx.K, 5, t)
+		compare(kx.Canonical, false, t)
+		compare(kx.Alphabet, "ACGT", t)
+		compare(kx.Len(), 4, t)
+	})
+	t.Run("sorted lookup", func(t *testing.T) {
+		kx := seqmer.ReadKIndex(bin)
+		kmer, count := kx.Kmer(0)
+		compare(kmer, "ACGTA", t)
+		compare(count, 300, t)
+		compare(kx.Count("GATTA"), 12, t)
+		compare(kx.Count("TTTTT"), 1, t)
+		compare(kx.Count("AAAAA"), 0, t)
+		compare(kx.Count("ACGT"), 0, t)
+	})
+	t.Run("round trip", func(t *testing.T) {
+		Check(kmers.Convert(bin, back, ""))
+		compare(readCounts(back, t), readCounts(tsv, t), t)
+	})
+	t.Run("unknown format", func(t *testing.T) {
+		if err := kmers.Convert(tsv, back, "fasta"); err == nil {
+			t.Errorf("expected an error for format fasta")
+		}
+	})
+}
+
+// counts of the given kmers in the set, with the set size
+func counts(kmers *seqmer.Oligos, kmer ...string) []int {
+	result := []int{kmers.Size()}
+	for _, k := range kmer {
+		result = append(result, kmers.Count(k))
+	}
+	return result
+}
+
+func TestSetOp(t *testing.T) {
+	a, _ := filepath.Abs("test_data/a.kcounts")
+	b, _ := filepath.Abs("test_data/b.kcounts")
+	// AAAAA: a=2, ACGTA: a=5 b=3, CCCCC: a=1 b=4, GGGGG: b=7
+	tests := []struct {
+		op      string
+		combine string
+		correct []int // size, AAAAA, ACGTA, CCCCC, GGGGG
+	}{
+		{"union", "", []int{4, 2, 8, 5, 7}},
+		{"union", "max", []int{4, 2, 5, 4, 7}},
+		{"intersect", "min", []int{2, 0, 3, 1, 0}},
+		{"intersect", "sum", []int{2, 0, 8, 5, 0}},
+		{"diff", "", []int{1, 2, 0, 0, 0}},
+		{"symdiff", "", []int{2, 2, 0, 0, 7}},
+	}
+	for _, test := range tests {
+		t.Run(test.op+"/"+test.combine, func(t *testing.T) {
+			kmers, err := kmers.SetOp(test.op, []string{a, b}, test.combine)
+			Check(err)
+			compare(counts(kmers, "AAAAA", "ACGTA", "CCCCC", "GGGGG"), test.correct, t)
+		})
+	}
+	t.Run("threshold", func(t *testing.T) {
+		kmers, err := kmers.SetOp("union", []string{a, b}, "sum")
+		Check(err)
+		kmers.Kthreshold(3, 7)
+		compare(counts(kmers, "AAAAA", "ACGTA", "CCCCC", "GGGGG"), []int{2, 0, 0, 5, 7}, t)
+	})
+	t.Run("bad combine", func(t *testing.T) {
+		if _, err := kmers.SetOp("union", []string{a, b}, "mean"); err == nil {
+			t.Errorf("expected an error for combine mean")
+		}
+	})
+}
+
+func TestContains(t *testing.T) {
+	small, _ := filepath.Abs("test_data/small.kcounts")
+	query, _ := filepath.Abs("test_data/query3.kcounts")
+	kqmers, qkmers := seqmer.Kcontains(
+		seqmer.ReadKcounts(small), seqmer.ReadKcounts(query))
+	compare(counts(kqmers, "ACGTT", "ACGTA", "TTTTT", "GATTA"), []int{3, 3, 300, 1, 0}, t)
+	compare(counts(qkmers, "CGT", "TTT", "CCC"), []int{2, 2, 3, 0}, t)
+}
@@ -0,0 +1,536 @@
+// Lineage assignment of haplotypes and sequences against lineage definitions,
+// given as defining mutations with parent lineages (the nextstrain clades.tsv
+// format).  Also annotates each variant with the lineages it defines, as
+// scripts/annotate_snvs_clade.py does.
+package lineage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	"annotation/amino"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+type cliargs struct {
+	Definitions string  `arg:"--definitions,required,help:lineage definitions: tab separated clade/gene/site/alt with gene nuc for nucleotide mutations and gene clade naming the parent in site."`
+	VCF         string  `arg:"--vcf,required,help:variants as written by classify (annotated by amino for amino acid mutations)."`
+	Haplotypes  string  `arg:"--haplotypes,help:assign the haplotypes in this file as written by haploscan."`
+	Members     string  `arg:"--members,help:assign the sequences in this membership file (table or sparse) as written by haploscan."`
+	MinFraction float64 `arg:"--min-fraction,help:lineages need at least this fraction of their defining mutations present (default 0.8)."`
+	Outfile     string  `arg:"--outfile,help:Output lineage assignments."`
+	Annotated   string  `arg:"--annotated,help:Output the vcf with the lineages each variant defines (LINEAGES)."`
+}
+
+func (c cliargs) Description() string {
+	return "Assign haplotypes or sequences to the lineage whose defining mutations they best fit."
+}
+
+// ============================================================================
+/// Structs/Related functions
+// ============================================================================
+
+// Lineage is a named lineage with its own defining mutations, keyed
+// gene:site+alt (eg nuc:23403G, S:614G), and its parent
+type Lineage struct {
+	Name      string
+	Parent    string
+	Mutations []string
+	defining  []string // with those inherited from the ancestors
+	ancestors map[string]bool
+}
+
+// Definitions holds the lineages in the order they were read, and the
+// lineages each mutation defines
+type Definitions struct {
+	lineages   map[string]*Lineage
+	order      []string
+	bymutation map[string][]string
+}
+
+// Sample is a haplotype or sequence to assign, with the IDs of its variants
+type Sample struct {
+	Name     string
+	Count    int
+	Variants []int
+}
+
+// Assignment is the best fitting lineage of a sample, the fraction of its
+// defining mutations present, those missing, and mutations of the sample
+// that define lineages off its line of descent (conflicts)
+type Assignment struct {
+	Name      string
+	Count     int
+	Lineage   string // "unassigned" when no lineage fits
+	Fraction  float64
+	Present   int
+	Defining  int
+	Missing   []string
+	Conflicts []string
+}
+
+// a mutation key, gene:site+alt
+func mutation(gene string, site int, alt string) string {
+	return fmt.Sprintf("%s:%d%s", gene, site, alt)
+}
+
+// the gene:site part of a mutation key, alt left off
+func mutationSite(key string) string {
+	return strings.TrimRight(key, "ACGTNRYKMSWBDHVacgtn-*ABCDEFGHIKLMNPQRSTVWXYZ")
+}
+
+// ============================================================================
+/// Reading
+// ============================================================================
+
+// Read lineage definitions: a header, then lineage, gene, site and alt per
+// line.  Gene nuc gives a nucleotide mutation, other genes an amino acid
+// mutation at codon site, and gene clade names the parent lineage in site.
+func ReadDefinitions(path string) (*Definitions, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &Definitions{lineages: make(map[string]*Lineage), bymutation: make(map[string][]string)}
+	lineageOf := func(name string) *Lineage {
+		if _, ok := d.lineages[name]; !ok {
+			d.lineages[name] = &Lineage{Name: name}
+			d.order = append(d.order, name)
+		}
+		return d.lineages[name]
+	}
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if line == 0 || strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s line %d: expected lineage, gene, site and alt", path, line+1)
+		}
+		l := lineageOf(fields[0])
+		if fields[1] == "clade" {
+			l.Parent = fields[2]
+			continue
+		}
+		if len(fields) < 4 || fields[3] == "" {
+			return nil, fmt.Errorf("%s line %d: no alt for %s", path, line+1, fields[0])
+		}
+		site, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line+1, err)
+		}
+		key := mutation(fields[1], site, fields[3])
+		l.Mutations = append(l.Mutations, key)
+		d.bymutation[key] = append(d.bymutation[key], l.Name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range d.order {
+		if err := d.inherit(d.lineages[name]); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return d, nil
+}
+
+// inherit sets the defining mutations of l from its ancestors, a lineage's own
+// mutation at a site taking the place of one inherited there (eg a reversion)
+func (d *Definitions) inherit(l *Lineage) error {
+	if l.ancestors != nil {
+		return nil
+	}
+	line := make([]*Lineage, 0) // l back to its root
+	ancestors := make(map[string]bool)
+	for at := l; at != nil; {
+		if ancestors[at.Name] {
+			return fmt.Errorf("lineage %s is its own ancestor", at.Name)
+		}
+		ancestors[at.Name] = true
+		line = append(line, at)
+		if at.Parent == "" {
+			break
+		}
+		parent, ok := d.lineages[at.Parent]
+		if !ok {
+			return fmt.Errorf("unknown parent %s of lineage %s", at.Parent, at.Name)
+		}
+		at = parent
+	}
+	bysite := make(map[string]string)
+	sites := make([]string, 0)
+	for i := len(line) - 1; i >= 0; i-- {
+		for _, key := range line[i].Mutations {
+			site := mutationSite(key)
+			if _, ok := bysite[site]; !ok {
+				sites = append(sites, site)
+			}
+			bysite[site] = key
+		}
+	}
+	for _, site := range sites {
+		l.defining = append(l.defining, bysite[site])
+	}
+	l.ancestors = ancestors
+	return nil
+}
+
+// Read the mutations of each variant in a classify vcf: nucleotide
+// substitutions and deletions (alt -), and with amino annotation the amino
+// acid changes of the GENE.  Insertions have no nucleotide mutations.
+func ReadVariantMutations(vcffile string) (map[int][]string, error) {
+	f, err := os.Open(vcffile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mutations := make(map[int][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, err := vcf.ParseVCFRecord(line)
+		if err != nil {
+			return nil, err
+		}
+		if id, err := strconv.Atoi(record.ID); err == nil {
+			mutations[id] = RecordMutations(record)
+		}
+	}
+	return mutations, scanner.Err()
+}
+
+// RecordMutations gives the mutation keys of a vcf record
+func RecordMutations(record *vcf.Record) []string {
+	keys := make([]string, 0)
+	if _, ok := record.Info["VARTYPE"]; !ok {
+		// substitutions only
+		if len(record.Ref) == len(record.Alt) {
+			for i := range record.Ref {
+				if record.Ref[i] != record.Alt[i] {
+					keys = append(keys, mutation("nuc", record.Pos+i, record.Alt[i:i+1]))
+				}
+			}
+		}
+	} else if record.Alt != "<INS>" {
+		pos, end, ref_seq, alt_seq := amino.RecordAlleles(record)
+		switch record.Info["VARTYPE"][0] {
+		case "SNP":
+			keys = append(keys, mutation("nuc", pos, alt_seq[:1]))
+		case "DEL", "DEL_REPEAT":
+			for site := pos; site <= end; site++ {
+				keys = append(keys, mutation("nuc", site, "-"))
+			}
+		case "COMPOUND":
+			// gapped alleles following pos; bases inserted in the reference gaps have no site
+			site := pos
+			for i := 0; i < len(ref_seq) && i < len(alt_seq); i++ {
+				if ref_seq[i] == '-' {
+					continue
+				}
+				site++
+				if alt_seq[i] != ref_seq[i] {
+					keys = append(keys, mutation("nuc", site, alt_seq[i:i+1]))
+				}
+			}
+		}
+	}
+
+	genes, changes := record.Info["GENE"], record.Info["AACHANGES"]
+	if len(genes) == 0 || genes[0] == "." {
+		return keys
+	}
+	for _, change := range changes {
+		at, to, ok := strings.Cut(change, ">")
+		codon := strings.TrimRight(at, "ABCDEFGHIKLMNPQRSTVWXYZ*-")
+		site, err := strconv.Atoi(codon)
+		if !ok || err != nil {
+			continue // . or AMBIGUOUS
+		}
+		keys = append(keys, mutation(genes[0], site, to))
+	}
+	return keys
+}
+
+// Read the haplotypes in a haplotype file as samples
+func ReadHaplotypes(hapfile string) []Sample {
+	haps := new(seqmer.Haplotypes)
+	haps.Init(0, "", 0)
+	haps.Read(hapfile)
+	samples := make([]Sample, 0)
+	for _, h := range haps.Summaries() {
+		samples = append(samples, Sample{strconv.Itoa(h.ID), h.Count, h.Variants})
+	}
+	return samples
+}
+
+// Read the sequences of a membership file as samples, from either the table
+// (sequence, hapID, nvariants, variants) or sparse (sequence, variantID) form.
+// Sequences without variants don't appear in the sparse form.
+func ReadMembers(path string) ([]Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	samples := make([]Sample, 0)
+	index := make(map[string]int)
+	sparse := false
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if line == 0 {
+			sparse = len(fields) == 2 && fields[1] == "variantID"
+			if !sparse && (len(fields) < 4 || fields[3] != "variants") {
+				return nil, fmt.Errorf("%s: not a membership table or sparse file", path)
+			}
+			continue
+		}
+		name := fields[0]
+		if _, ok := index[name]; !ok {
+			index[name] = len(samples)
+			samples = append(samples, Sample{Name: name, Count: 1})
+		}
+		s := &samples[index[name]]
+		list := fields[1]
+		if !sparse {
+			list = fields[3]
+		}
+		for _, word := range strings.Split(list, ",") {
+			if word == "" {
+				continue
+			}
+			vID, err := strconv.Atoi(word)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", path, line+1, err)
+			}
+			s.Variants = append(s.Variants, vID)
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// ============================================================================
+/// Assignment
+// ============================================================================
+
+// Assign gives the sample the lineage with the most of its defining mutations
+// present, among those with at least minfraction of them present; ties go to
+// the higher fraction, then the lineage defined first.  Variants missing from
+// the vcf mutations are ignored.
+func (d *Definitions) Assign(sample Sample, mutations map[int][]string, minfraction float64) Assignment {
+	has := make(map[string]bool)
+	for _, vID := range sample.Variants {
+		for _, key := range mutations[vID] {
+			has[key] = true
+		}
+	}
+
+	a := Assignment{Name: sample.Name, Count: sample.Count, Lineage: "unassigned"}
+	var best *Lineage
+	for _, name := range d.order {
+		l := d.lineages[name]
+		present := 0
+		for _, key := range l.defining {
+			if has[key] {
+				present++
+			}
+		}
+		fraction := 1.0 // a root without defining mutations fits anything
+		if len(l.defining) > 0 {
+			fraction = float64(present) / float64(len(l.defining))
+		}
+		if fraction < minfraction {
+			continue
+		}
+		if best == nil || present > a.Present || (present == a.Present && fraction > a.Fraction) {
+			best = l
+			a.Lineage, a.Fraction, a.Present, a.Defining = l.Name, fraction, present, len(l.defining)
+		}
+	}
+	if best == nil {
+		return a
+	}
+
+	for _, key := range best.defining {
+		if !has[key] {
+			a.Missing = append(a.Missing, key)
+		}
+	}
+	defining := make(map[string]bool)
+	for _, key := range best.defining {
+		defining[key] = true
+	}
+	for key := range has {
+		if defining[key] {
+			continue
+		}
+		// a mutation defining only lineages off the line of descent
+		conflict := false
+		for _, name := range d.bymutation[key] {
+			if best.ancestors[name] {
+				conflict = false
+				break
+			}
+			conflict = true
+		}
+		if conflict {
+			a.Conflicts = append(a.Conflicts, fmt.Sprintf("%s(%s)", key, strings.Join(d.bymutation[key], "/")))
+		}
+	}
+	sort.Strings(a.Conflicts)
+	return a
+}
+
+// AssignAll assigns each sample
+func (d *Definitions) AssignAll(samples []Sample, mutations map[int][]string, minfraction float64) []Assignment {
+	assignments := make([]Assignment, len(samples))
+	for i, sample := range samples {
+		assignments[i] = d.Assign(sample, mutations, minfraction)
+	}
+	return assignments
+}
+
+// Lineages gives the lineages a variant's mutations define, in definition order
+func (d *Definitions) Lineages(keys []string) []string {
+	defines := make(map[string]bool)
+	for _, key := range keys {
+		for _, name := range d.bymutation[key] {
+			defines[name] = true
+		}
+	}
+	lineages := make([]string, 0)
+	for _, name := range d.order {
+		if defines[name] {
+			lineages = append(lineages, name)
+		}
+	}
+	return lineages
+}
+
+// ============================================================================
+/// Output
+// ============================================================================
+
+// a comma separated list, - when empty
+func joined(words []string) string {
+	if len(words) == 0 {
+		return "-"
+	}
+	return strings.Join(words, ",")
+}
+
+func WriteAssignments(path string, assignments []Assignment) {
+	f, err := os.Create(path)
+	Check(err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	fmt.Fprintln(w, "ID\tcount\tlineage\tfraction\tpresent\tdefining\tmissing\tconflicts")
+	for _, a := range assignments {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.4f\t%d\t%d\t%s\t%s\n", a.Name, a.Count, a.Lineage, a.Fraction,
+			a.Present, a.Defining, joined(a.Missing), joined(a.Conflicts))
+	}
+}
+
+// AnnotateLineages copies the vcf adding the LINEAGES each variant defines
+// (. for none), spaces in lineage names written as _
+func (d *Definitions) AnnotateLineages(vcffile string, outfile string) {
+	v, err := os.Open(vcffile)
+	Check(err)
+	defer v.Close()
+	out, err := os.Create(outfile)
+	Check(err)
+	defer out.Close()
+
+	vcf.ParseVCFHeader(v).
+		AddInfo("LINEAGES", ".", "String", "Lineages the variant is a defining mutation of.").
+		Write(out)
+	scanner := bufio.NewScanner(v)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, err := vcf.ParseVCFRecord(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "**Warning**:%s\n**SKIPPING**\n\n", err)
+			continue
+		}
+		lineages := d.Lineages(RecordMutations(record))
+		if len(lineages) == 0 {
+			lineages = []string{"."}
+		}
+		for i := range lineages {
+			lineages[i] = strings.ReplaceAll(lineages[i], " ", "_")
+		}
+		record.AddInfo("LINEAGES", lineages...).Write(out)
+	}
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+	if cli.Outfile == "" && cli.Annotated == "" {
+		p.Fail("no output, use --outfile and/or --annotated")
+	}
+	if cli.Outfile != "" && (cli.Haplotypes == "") == (cli.Members == "") {
+		p.Fail("assigning lineages needs one of --haplotypes or --members")
+	}
+	if cli.MinFraction == 0 {
+		cli.MinFraction = 0.8
+	}
+
+	defpath, err := filepath.Abs(cli.Definitions)
+	Check(err)
+	d, err := ReadDefinitions(defpath)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	vcfpath, err := filepath.Abs(cli.VCF)
+	Check(err)
+
+	if cli.Annotated != "" {
+		outpath, err := filepath.Abs(cli.Annotated)
+		Check(err)
+		d.AnnotateLineages(vcfpath, outpath)
+	}
+	if cli.Outfile == "" {
+		return
+	}
+	mutations, err := ReadVariantMutations(vcfpath)
+	Check(err)
+	var samples []Sample
+	if cli.Haplotypes != "" {
+		hapfile, err := filepath.Abs(cli.Haplotypes)
+		Check(err)
+		samples = ReadHaplotypes(hapfile)
+	} else {
+		memberfile, err := filepath.Abs(cli.Members)
+		Check(err)
+		samples, err = ReadMembers(memberfile)
+		if err != nil {
+			p.Fail(err.Error())
+		}
+	}
+	outpath, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	WriteAssignments(outpath, d.AssignAll(samples, mutations, cli.MinFraction))
+}
@@ -0,0 +1,106 @@
+package lineage_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"annotation/lineage"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestMutations(t *testing.T) {
+	vcffile, _ := filepath.Abs("test_data/variants.vcf")
+	mutations, err := lineage.ReadVariantMutations(vcffile)
+	Check(err)
+	compare(mutations[1], []string{"nuc:100T"}, t)
+	compare(mutations[3], []string{"nuc:300C", "S:10K"}, t)
+	compare(mutations[5], []string{"nuc:500-"}, t)
+
+	t.Run("compound", func(t *testing.T) {
+		record, err := vcf.ParseVCFRecord("contig\t10\t7\tACG\tATT\t.\t.\tVARTYPE=COMPOUND;END=12")
+		Check(err)
+		compare(lineage.RecordMutations(record), []string{"nuc:11T", "nuc:12T"}, t)
+	})
+	t.Run("unknown type", func(t *testing.T) {
+		record, err := vcf.ParseVCFRecord("contig\t10\t7\tAC\tGC\t.\t.\tCOUNT=1")
+		Check(err)
+		compare(lineage.RecordMutations(record), []string{"nuc:10G"}, t)
+	})
+}
+
+func TestAssign(t *testing.T) {
+	defpath, _ := filepath.Abs("test_data/clades.tsv")
+	vcffile, _ := filepath.Abs("test_data/variants.vcf")
+	d, err := lineage.ReadDefinitions(defpath)
+	Check(err)
+	mutations, err := lineage.ReadVariantMutations(vcffile)
+	Check(err)
+
+	t.Run("haplotypes", func(t *testing.T) {
+		hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+		assignments := d.AssignAll(lineage.ReadHaplotypes(hapfile), mutations, 0.8)
+		names := make([]string, 0)
+		for _, a := range assignments {
+			names = append(names, a.Lineage)
+		}
+		compare(names, []string{"root", "A", "B", "C", "B", "root"}, t)
+		// B inherits the mutations of A
+		compare(assignments[2], lineage.Assignment{"2", 4, "B", 1, 4, 4, nil, nil}, t)
+		compare(assignments[4].Conflicts, []string{"nuc:400A(C)"}, t)
+		compare(assignments[5].Conflicts, []string{"nuc:100T(A)"}, t)
+	})
+	t.Run("partial", func(t *testing.T) {
+		sample := lineage.Sample{"partial", 1, []int{1, 2, 4}}
+		compare(d.Assign(sample, mutations, 0.5), lineage.Assignment{"partial", 1, "C", 0.75, 3, 4, []string{"nuc:500-"}, nil}, t)
+		compare(d.Assign(sample, mutations, 0.8).Lineage, "A", t)
+		compare(d.Assign(sample, mutations, 1.01).Lineage, "unassigned", t)
+	})
+	t.Run("members", func(t *testing.T) {
+		for _, file := range []string{"test_data/members.xls", "test_data/members_sparse.xls"} {
+			memberfile, _ := filepath.Abs(file)
+			samples, err := lineage.ReadMembers(memberfile)
+			Check(err)
+			assignments := d.AssignAll(samples, mutations, 0.8)
+			compare(assignments[0].Lineage, "A", t)
+			compare(assignments[1].Lineage, "C", t)
+		}
+	})
+	t.Run("unknown parent", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "clades.tsv")
+		Check(os.WriteFile(bad, []byte("clade\tgene\tsite\talt\nB\tclade\tA\t\n"), 0644))
+		if _, err := lineage.ReadDefinitions(bad); err == nil {
+			t.Error("expected an error for the unknown parent A")
+		}
+	})
+}
+
+func TestAnnotate(t *testing.T) {
+	defpath, _ := filepath.Abs("test_data/clades.tsv")
+	vcffile, _ := filepath.Abs("test_data/variants.vcf")
+	d, err := lineage.ReadDefinitions(defpath)
+	Check(err)
+	outfile := filepath.Join(t.TempDir(), "annotated.vcf")
+	d.AnnotateLineages(vcffile, outfile)
+
+	data, err := os.ReadFile(outfile)
+	Check(err)
+	lineages := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			record, err := vcf.ParseVCFRecord(line)
+			Check(err)
+			lineages = append(lineages, strings.Join(record.Info["LINEAGES"], ","))
+		}
+	}
+	compare(lineages, []string{"A", "A", "B", "C", "C", "."}, t)
+}
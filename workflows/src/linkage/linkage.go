@@ -0,0 +1,80 @@
+// Variant co-occurrence and linkage disequilibrium over the haplotype files
+// written by seqmer.
+package linkage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+type cliargs struct {
+	Haplotypes  string  `arg:"--haplotypes,required,help:haplotype file as written by haploscan."`
+	Outfile     string  `arg:"--outfile,required,help:Output table of variant pairs."`
+	MinFreq     float64 `arg:"--min-freq,help:only pair variants in at least this fraction of sequences."`
+	Variants    string  `arg:"--variants,help:vcf from anvir classify; gives variant positions for the output and --from/--to."`
+	From        int     `arg:"--from,help:only pair variants at or after this position; needs --variants."`
+	To          int     `arg:"--to,help:only pair variants at or before this position; needs --variants."`
+	Cooccurring bool    `arg:"--cooccurring,help:only write pairs seen together (a sparse matrix)."`
+}
+
+func (c cliargs) Description() string {
+	return "Co-occurrence counts and linkage disequilibrium (D' and r2) of variant pairs."
+}
+
+// Compute the linkage of the variant pairs in hapfile with frequencies of at
+// least minfreq.  vcffile, if not "", places the variants, and only those
+// from from to to (0 for no limit) are used.  cooccurring drops pairs never
+// seen together.
+func Compute(hapfile string, vcffile string, minfreq float64, from int, to int, cooccurring bool) ([]*seqmer.Linkpair, error) {
+	var positions map[int]int
+	if vcffile != "" {
+		var err error
+		positions, err = vcf.ReadIDPositions(vcffile)
+		if err != nil {
+			return nil, err
+		}
+	} else if from != 0 || to != 0 {
+		return nil, fmt.Errorf("a window needs variant positions from a vcf")
+	}
+	haps := new(seqmer.Haplotypes)
+	haps.Init(0, "", 0)
+	haps.Read(hapfile)
+
+	pairs := haps.Linkage(minfreq, positions, from, to)
+	if cooccurring {
+		seen := make([]*seqmer.Linkpair, 0, len(pairs))
+		for _, pair := range pairs {
+			if pair.CountAB > 0 {
+				seen = append(seen, pair)
+			}
+		}
+		pairs = seen
+	}
+	return pairs, nil
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+
+	hapfile, err := filepath.Abs(cli.Haplotypes)
+	Check(err)
+	outfile, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	vcffile := cli.Variants
+	if vcffile != "" {
+		vcffile, err = filepath.Abs(vcffile)
+		Check(err)
+	}
+	pairs, err := Compute(hapfile, vcffile, cli.MinFreq, cli.From, cli.To, cli.Cooccurring)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	seqmer.LinkagePrint(outfile, pairs)
+}
@@ -0,0 +1,71 @@
+package linkage_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"annotation/linkage"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	vcffile, _ := filepath.Abs("test_data/variants.vcf")
+
+	t.Run("all pairs", func(t *testing.T) {
+		// variant 7 is in 2 of 43 sequences, under the threshold, leaving 6 variants
+		pairs, err := linkage.Compute(hapfile, "", 0.1, 0, 0, false)
+		Check(err)
+		compare(len(pairs), 15, t)
+		first := pairs[0]
+		compare([]int{first.A, first.B, first.CountA, first.CountB, first.CountAB, first.Total},
+			[]int{1, 2, 18, 17, 17, 43}, t)
+		compare(first.Dprime, 1.0, t)
+		compare(math.Round(first.Rsquared*10000)/10000, 0.9081, t)
+	})
+	t.Run("window", func(t *testing.T) {
+		pairs, err := linkage.Compute(hapfile, vcffile, 0.1, 100, 300, false)
+		Check(err)
+		compare(len(pairs), 3, t)
+	})
+	t.Run("unplaced variants without a window", func(t *testing.T) {
+		// variant 6 is not in the vcf, but is only dropped by a window
+		data, err := os.ReadFile(vcffile)
+		Check(err)
+		partial := filepath.Join(t.TempDir(), "partial.vcf")
+		Check(os.WriteFile(partial, []byte(strings.Replace(string(data),
+			"contig\t600\t6\tA\tG\t.\t.\tVARTYPE=SNP;END=600;COUNT=1\n", "", 1)), 0644))
+		pairs, err := linkage.Compute(hapfile, partial, 0.1, 0, 0, false)
+		Check(err)
+		compare(len(pairs), 15, t)
+		pairs, err = linkage.Compute(hapfile, partial, 0.1, 1, 0, false)
+		Check(err)
+		compare(len(pairs), 10, t)
+	})
+	t.Run("cooccurring", func(t *testing.T) {
+		// 3 never occurs with 4, 5 or 6
+		pairs, err := linkage.Compute(hapfile, "", 0.1, 0, 0, true)
+		Check(err)
+		compare(len(pairs), 12, t)
+		for _, pair := range pairs {
+			if pair.A == 3 && pair.B > 3 {
+				t.Errorf("pair %d %d never co-occurs", pair.A, pair.B)
+			}
+		}
+	})
+	t.Run("window without positions", func(t *testing.T) {
+		if _, err := linkage.Compute(hapfile, "", 0.1, 100, 300, false); err == nil {
+			t.Error("expected an error for a window without a vcf")
+		}
+	})
+}
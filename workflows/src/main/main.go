@@ -8,6 +8,15 @@ import (
 
 	"annotation/amino"
 	"annotation/classify_variants"
+	"annotation/frequencies"
+	"annotation/kmers"
+	"annotation/lineage"
+	"annotation/linkage"
+	"annotation/network"
+	"annotation/recombinants"
+	"annotation/runs"
+	"annotation/tree"
+	"annotation/typing"
 	"annotation/queryposition"
 	"annotation/querywindow"
 )
@@ -15,6 +24,16 @@ import (
 var subprograms = map[string]func(){
 	"classify": classify_variants.Main,
 	"amino": amino.Main,
+	"kmers": kmers.Main,
+	"network": network.Main,
+	"tree": tree.Main,
+	"recombinants": recombinants.Main,
+	"linkage": linkage.Main,
+	"frequencies": frequencies.Main,
+	"lineage": lineage.Main,
+	"type": typing.Main,
+	"merge": runs.MergeMain,
+	"diff": runs.DiffMain,
 	"querywindow": querywindow.Main,
 	"queryposition": queryposition.Main,
 	// add more as we get more pieces
@@ -26,6 +45,16 @@ anvir -- usage:
 Subprograms:
 	classify:    classify variants from raw deviant/anchor sequences
 	amino:       annotate variants in genes with amino acid changes that span the variant
+	kmers:       .kcounts files: convert, union/intersect/diff/symdiff, contains
+	network:     haplotype networks: build, with DOT/GraphML/Cytoscape JSON export, render to SVG
+	tree:        neighbor joining tree of haplotypes, with parsimony variant mapping, to Newick/Nexus
+	recombinants: haplotypes that are mosaics of two parents, with breakpoint intervals
+	linkage:     co-occurrence and linkage disequilibrium (D', r2) of variant pairs
+	frequencies: variant/haplotype counts and frequencies by date bin and place, with logistic growth fits
+	lineage:     assign haplotypes/sequences to lineages by their defining mutations; annotate variants
+	type:        type new sequences against a saved reference kmer set and variant catalogue
+	merge:       merge variant tables or classified vcfs of several runs, with per-source counts
+	diff:        variants gained, lost or changed in frequency between two runs (z test, BH corrected)
     querywindow: sequence query reference to get genomic position of sequence
     queryposition: given genomic position, get sequence (1-based closed interval)
 `
@@ -0,0 +1,157 @@
+// Haplotype networks from the haplotype files written by seqmer.
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+)
+
+type inputargs struct {
+	Haplotypes string `arg:"--haplotypes,required,help:haplotype file as written by haploscan."`
+	Metadata   string `arg:"--metadata,help:haplotype breakdown by time and place as written by haploscan; for node summaries and colors."`
+	Method     string `arg:"--method,help:msn (minimum spanning network; default) or mjn (median joining)."`
+	MinCount   int    `arg:"--min-count,help:leave out haplotypes seen fewer times than this."`
+}
+
+type buildargs struct {
+	inputargs
+	Edges   string `arg:"--edges,required,help:Output edge list."`
+	Nodes   string `arg:"--nodes,help:Output haplotypes in the network including any medians."`
+	Dot     string `arg:"--dot,help:Output Graphviz DOT graph."`
+	GraphML string `arg:"--graphml,help:Output GraphML graph."`
+	JSON    string `arg:"--json,help:Output Cytoscape JSON graph."`
+}
+
+type renderargs struct {
+	inputargs
+	SVG     string `arg:"--svg,required,help:Output SVG drawing."`
+	Layout  string `arg:"--layout,help:force (force directed; default) or layered (rows by variant count)."`
+	ColorBy string `arg:"--color-by,help:color nodes by their most common date/region/country/division; needs --metadata."`
+}
+
+type cliargs struct {
+	Build  *buildargs  `arg:"subcommand:build" help:"build a haplotype network, with optional DOT, GraphML and Cytoscape JSON graphs"`
+	Render *renderargs `arg:"subcommand:render" help:"draw a haplotype network as SVG"`
+}
+
+func (c cliargs) Description() string {
+	return "Haplotype networks."
+}
+
+// ============================================================================
+/// Build
+// ============================================================================
+
+// Build reads the haplotypes in hapfile and joins those seen at least mincount
+// times into a network.  method is "msn" or "mjn", "" is msn.  metafile, if
+// not "", is a haplotype breakdown by time and place to summarize on the nodes.
+func Build(hapfile string, metafile string, mincount int, method string) (*seqmer.Network, error) {
+	if method == "" {
+		method = "msn"
+	}
+	if method != "msn" && method != "mjn" {
+		return nil, fmt.Errorf("unknown network method %q, expected msn or mjn", method)
+	}
+	haps := new(seqmer.Haplotypes)
+	haps.Init(0, "", 0)
+	haps.Read(hapfile)
+	if metafile != "" {
+		haps.ReadMeta(metafile)
+	}
+	return haps.Network(mincount, method), nil
+}
+
+// ============================================================================
+/// Render
+// ============================================================================
+
+// Render draws net to svgfile.  layout is "force" or "layered", "" is force;
+// colorby is a metadata field (date, region, country or division) or "".
+func Render(net *seqmer.Network, svgfile string, layout string, colorby string) error {
+	if layout == "" {
+		layout = "force"
+	}
+	if layout != "force" && layout != "layered" {
+		return fmt.Errorf("unknown network layout %q, expected force or layered", layout)
+	}
+	switch colorby {
+	case "", "date", "region", "country", "division":
+	default:
+		return fmt.Errorf("unknown metadata field %q, expected date, region, country or division", colorby)
+	}
+	net.Render(svgfile, layout, colorby)
+	return nil
+}
+
+func buildInput(args inputargs) (*seqmer.Network, error) {
+	hapfile, err := filepath.Abs(args.Haplotypes)
+	Check(err)
+	metafile := args.Metadata
+	if metafile != "" {
+		metafile, err = filepath.Abs(metafile)
+		Check(err)
+	}
+	return Build(hapfile, metafile, args.MinCount, args.Method)
+}
+
+func runBuild(args *buildargs) error {
+	net, err := buildInput(args.inputargs)
+	if err != nil {
+		return err
+	}
+	edgefile, err := filepath.Abs(args.Edges)
+	Check(err)
+	net.EdgePrint(edgefile)
+	if args.Nodes != "" {
+		nodefile, err := filepath.Abs(args.Nodes)
+		Check(err)
+		net.NodePrint(nodefile)
+	}
+	graphs := []struct{ format, graphfile string }{{"dot", args.Dot}, {"graphml", args.GraphML}, {"json", args.JSON}}
+	for _, graph := range graphs {
+		if format, graphfile := graph.format, graph.graphfile; graphfile != "" {
+			graphpath, err := filepath.Abs(graphfile)
+			Check(err)
+			net.Export(graphpath, format)
+		}
+	}
+	return nil
+}
+
+func runRender(args *renderargs) error {
+	if args.ColorBy != "" && args.Metadata == "" {
+		return fmt.Errorf("--color-by needs --metadata")
+	}
+	net, err := buildInput(args.inputargs)
+	if err != nil {
+		return err
+	}
+	svgfile, err := filepath.Abs(args.SVG)
+	Check(err)
+	return Render(net, svgfile, args.Layout, args.ColorBy)
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+
+	var err error
+	switch {
+	case cli.Build != nil:
+		err = runBuild(cli.Build)
+	case cli.Render != nil:
+		err = runRender(cli.Render)
+	default:
+		p.WriteHelp(os.Stdout)
+		os.Exit(1)
+	}
+	if err != nil {
+		p.Fail(err.Error())
+	}
+}
@@ -0,0 +1,156 @@
+package network_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"annotation/network"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+type edge struct {
+	from, to, distance, weight int
+	gained, lost               []int
+}
+
+func build(method string, t *testing.T) []edge {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	net, err := network.Build(hapfile, "", 2, method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := make([]edge, 0)
+	for _, e := range net.Edges() {
+		edges = append(edges, edge{e.From, e.To, e.Distance, e.Weight, e.Gained, e.Lost})
+	}
+	return edges
+}
+
+func TestBuild(t *testing.T) {
+	t.Run("minimum spanning network", func(t *testing.T) {
+		// all four haplotypes are two variants apart, so every pair is joined
+		compare(len(build("msn", t)), 6, t)
+		compare(build("msn", t)[0], edge{0, 1, 2, 15, []int{1, 2}, []int{}}, t)
+	})
+	t.Run("median joining network", func(t *testing.T) {
+		// the median {1} (ID 5, after the last haplotype read) joins them in a star
+		correct := []edge{
+			{0, 5, 1, 10, []int{1}, []int{}},
+			{5, 1, 1, 5, []int{2}, []int{}},
+			{5, 2, 1, 4, []int{3}, []int{}},
+			{5, 3, 1, 3, []int{4}, []int{}},
+		}
+		compare(build("mjn", t), correct, t)
+	})
+	t.Run("unknown method", func(t *testing.T) {
+		if _, err := network.Build("test_data/haplotypes.xls", "", 2, "nj"); err == nil {
+			t.Error("expected an error for method nj")
+		}
+	})
+}
+
+func TestExport(t *testing.T) {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	metafile, _ := filepath.Abs("test_data/haplotypes_meta.xls")
+	net, err := network.Build(hapfile, metafile, 2, "msn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	t.Run("cytoscape json", func(t *testing.T) {
+		path := filepath.Join(dir, "net.json")
+		net.Export(path, "json")
+		data, err := os.ReadFile(path)
+		Check(err)
+		var graph struct {
+			Elements struct {
+				Nodes []struct{ Data map[string]interface{} }
+				Edges []struct{ Data map[string]interface{} }
+			}
+		}
+		Check(json.Unmarshal(data, &graph))
+		compare(len(graph.Elements.Nodes), 4, t)
+		compare(len(graph.Elements.Edges), 6, t)
+		node := graph.Elements.Nodes[1].Data
+		compare(node["id"], interface{}("h1"), t)
+		compare(node["first"], interface{}("2021-10-11"), t)
+		compare(node["last"], interface{}("2021-12-01"), t)
+		compare(node["countries"], interface{}("United Kingdom:4,USA:1"), t)
+		compare(graph.Elements.Edges[0].Data["gained"], interface{}([]interface{}{1.0, 2.0}), t)
+	})
+	t.Run("graphml", func(t *testing.T) {
+		path := filepath.Join(dir, "net.graphml")
+		net.Export(path, "graphml")
+		data, err := os.ReadFile(path)
+		Check(err)
+		var graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"graph>node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"graph>edge"`
+		}
+		Check(xml.Unmarshal(data, &graph))
+		compare(len(graph.Nodes), 4, t)
+		compare(graph.Edges[0].Source+">"+graph.Edges[0].Target, "h0>h1", t)
+	})
+	t.Run("dot", func(t *testing.T) {
+		path := filepath.Join(dir, "net.dot")
+		net.Export(path, "dot")
+		data, err := os.ReadFile(path)
+		Check(err)
+		if !strings.Contains(string(data), `h0 -> h1 [label="1,2"`) {
+			t.Errorf("missing edge h0 -> h1 in\n%s", data)
+		}
+	})
+}
+
+func TestRender(t *testing.T) {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	metafile, _ := filepath.Abs("test_data/haplotypes_meta.xls")
+	net, err := network.Build(hapfile, metafile, 2, "mjn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	for _, layout := range []string{"force", "layered"} {
+		t.Run(layout, func(t *testing.T) {
+			path := filepath.Join(dir, layout+".svg")
+			Check(network.Render(net, path, layout, "country"))
+			data, err := os.ReadFile(path)
+			Check(err)
+			var svg struct {
+				Lines   []struct{} `xml:"g>line"`
+				Circles []struct {
+					Fill string `xml:"fill,attr"`
+				} `xml:"g>circle"`
+			}
+			Check(xml.Unmarshal(data, &svg))
+			compare(len(svg.Lines), 4, t)
+			// five haplotypes with the median, then the legend for the United Kingdom and USA
+			compare(len(svg.Circles), 7, t)
+			compare(svg.Circles[1].Fill, svg.Circles[5].Fill, t) // haplotype 1 is mostly United Kingdom
+			compare(svg.Circles[4].Fill, "white", t)             // the median
+		})
+	}
+	t.Run("unknown layout", func(t *testing.T) {
+		if network.Render(net, filepath.Join(dir, "x.svg"), "circular", "") == nil {
+			t.Error("expected an error for layout circular")
+		}
+	})
+}
@@ -0,0 +1,46 @@
+// Reference sequence at a genomic position.
+package queryposition
+
+import (
+	"fmt"
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"annotation/fastaseq"
+	. "annotation/utils"
+)
+
+type cliargs struct {
+	Reference string `arg:"--reference,required,help:Reference fasta."`
+	Start     int    `arg:"-s,required,help:start position (1-based)."`
+	End       int    `arg:"-e,required,help:end position (1-based)."`
+}
+
+func (c cliargs) Description() string {
+	return "Reference sequence between the {start} and {end} positions (1-based closed interval)."
+}
+
+// QueryPosition returns the sequence from start to end (1-based, closed) of
+// the reference in ref_fasta, or an error if the interval is not within it.
+func QueryPosition(ref_fasta string, start int, end int) (string, error) {
+	ref := fastaseq.LoadContiguousReference(ref_fasta)
+	if start < 1 || end < start || end > ref.Length() {
+		return "", fmt.Errorf("interval %d-%d is not within the reference (1-%d)",
+			start, end, ref.Length())
+	}
+	return ref.Query(start, end), nil
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+
+	refpath, err := filepath.Abs(cli.Reference)
+	Check(err)
+	seq, err := QueryPosition(refpath, cli.Start, cli.End)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	fmt.Println(seq)
+}
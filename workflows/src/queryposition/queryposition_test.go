@@ -0,0 +1,36 @@
+package queryposition_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"annotation/queryposition"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestQueryPosition(t *testing.T) {
+	ref, _ := filepath.Abs("test_data/ref.fa")
+
+	t.Run("interval", func(t *testing.T) {
+		seq, err := queryposition.QueryPosition(ref, 9, 13)
+		compare(seq, "GGCGC", t)
+		compare(err, nil, t)
+	})
+	t.Run("across lines", func(t *testing.T) {
+		seq, _ := queryposition.QueryPosition(ref, 16, 19)
+		compare(seq, "ATTT", t)
+	})
+	t.Run("out of range", func(t *testing.T) {
+		for _, interval := range [][2]int{{0, 3}, {5, 4}, {30, 35}} {
+			if _, err := queryposition.QueryPosition(ref, interval[0], interval[1]); err == nil {
+				t.Errorf("expected an error for %v", interval)
+			}
+		}
+	})
+}
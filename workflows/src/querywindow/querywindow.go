@@ -0,0 +1,42 @@
+// Genomic positions of a sequence in the reference.
+package querywindow
+
+import (
+	"fmt"
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"annotation/fastaseq"
+	. "annotation/utils"
+)
+
+type cliargs struct {
+	Reference string `arg:"--reference,required,help:Reference fasta."`
+	Query     string `arg:"--query,required,help:Sequence query."`
+}
+
+func (c cliargs) Description() string {
+	return "Genomic positions (1-based closed intervals) of the {query} sequence in the {reference}."
+}
+
+// QueryWindow returns every placement of query in the reference in ref_fasta,
+// as 1-based closed intervals.
+func QueryWindow(ref_fasta string, query string) []Interval {
+	return fastaseq.LoadWindowedReference(ref_fasta, len(query)).Query(query)
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+	if cli.Query == "" {
+		p.Fail("--query must not be empty")
+	}
+
+	refpath, err := filepath.Abs(cli.Reference)
+	Check(err)
+	contig := fastaseq.LoadContiguousReference(refpath).Contig
+	for _, loc := range QueryWindow(refpath, cli.Query) {
+		fmt.Printf("%s\t%d\t%d\n", contig, loc.Start, loc.End)
+	}
+}
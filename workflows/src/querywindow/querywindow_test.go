@@ -0,0 +1,31 @@
+package querywindow_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"annotation/querywindow"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestQueryWindow(t *testing.T) {
+	ref, _ := filepath.Abs("test_data/ref.fa")
+
+	t.Run("once", func(t *testing.T) {
+		compare(querywindow.QueryWindow(ref, "GGCGC"), []Interval{{Start: 9, End: 13}}, t)
+	})
+	t.Run("repeated", func(t *testing.T) {
+		compare(querywindow.QueryWindow(ref, "TCGA"),
+			[]Interval{{Start: 2, End: 5}, {Start: 24, End: 27}}, t)
+	})
+	t.Run("absent", func(t *testing.T) {
+		compare(len(querywindow.QueryWindow(ref, "AAAAA")), 0, t)
+	})
+}
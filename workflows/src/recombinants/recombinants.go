@@ -0,0 +1,51 @@
+// Recombinant haplotype detection over the haplotype files written by seqmer.
+package recombinants
+
+import (
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+type cliargs struct {
+	Haplotypes string `arg:"--haplotypes,required,help:haplotype file as written by haploscan."`
+	Variants   string `arg:"--variants,required,help:vcf from anvir classify; gives the position of each variant ID."`
+	Outfile    string `arg:"--outfile,required,help:Output table of recombinant haplotypes."`
+	MinCount   int    `arg:"--min-count,help:only test and use haplotypes seen at least this many times."`
+	Penalty    int    `arg:"--penalty,help:cost of a recombination in mutations (default 1)."`
+}
+
+func (c cliargs) Description() string {
+	return "Find haplotypes that are mosaics of two more common haplotypes split at a breakpoint."
+}
+
+// Find the recombinant haplotypes in hapfile, with the variant positions in
+// vcffile.  A penalty of 0 or less is 1.
+func Find(hapfile string, vcffile string, mincount int, penalty int) []*seqmer.Recombinant {
+	if penalty <= 0 {
+		penalty = 1
+	}
+	haps := new(seqmer.Haplotypes)
+	haps.Init(0, "", 0)
+	haps.Read(hapfile)
+	positions, err := vcf.ReadIDPositions(vcffile)
+	Check(err)
+	return haps.Recombinants(positions, mincount, penalty)
+}
+
+func Main() {
+	cli := cliargs{}
+	arg.MustParse(&cli)
+
+	hapfile, err := filepath.Abs(cli.Haplotypes)
+	Check(err)
+	vcffile, err := filepath.Abs(cli.Variants)
+	Check(err)
+	outfile, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	seqmer.RecombinantPrint(outfile, Find(hapfile, vcffile, cli.MinCount, cli.Penalty))
+}
@@ -0,0 +1,43 @@
+package recombinants_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"annotation/recombinants"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestFind(t *testing.T) {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	vcffile, _ := filepath.Abs("test_data/variants.vcf")
+
+	t.Run("positions", func(t *testing.T) {
+		positions, err := vcf.ReadIDPositions(vcffile)
+		Check(err)
+		compare(len(positions), 7, t)
+		compare(positions[3], 300, t)
+	})
+	t.Run("mosaic", func(t *testing.T) {
+		// haplotype 3 is 1 and 2 from haplotype 1, then 4 to 6 from haplotype 2;
+		// haplotype 4 is haplotype 1 with one more mutation, so is not reported
+		found := recombinants.Find(hapfile, vcffile, 1, 0)
+		compare(len(found), 1, t)
+		rec := *found[0]
+		compare([]int{rec.Child, rec.Left, rec.Right, rec.BreakFrom, rec.BreakTo, rec.Extra},
+			[]int{3, 1, 2, 200, 300, 0}, t)
+		compare([]int{rec.Single, rec.SingleDiff}, []int{2, 2}, t)
+	})
+	t.Run("penalty", func(t *testing.T) {
+		// a recombination costing two mutations is no better than haplotype 2
+		compare(len(recombinants.Find(hapfile, vcffile, 1, 2)), 0, t)
+	})
+}
@@ -0,0 +1,455 @@
+// Merging and comparing the variant tables (tagvars) or classified vcfs
+// (anvir classify) of several runs, eg different months, regions or k.
+package runs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+type mergeargs struct {
+	Infiles []string `arg:"positional,required,help:variant tables or classified vcfs to merge; all of one kind."`
+	Outfile string   `arg:"--outfile,required,help:Output merged variant table or vcf."`
+	Counts  string   `arg:"--counts,help:Output table of the count and ID of each variant in each source."`
+}
+
+func (c mergeargs) Description() string {
+	return "Merge variant tables or classified vcfs: counts are summed and IDs reconciled."
+}
+
+type diffargs struct {
+	Before     string  `arg:"positional,required,help:variant table or classified vcf of the first run."`
+	After      string  `arg:"positional,required,help:variant table or classified vcf of the second run."`
+	Outfile    string  `arg:"--outfile,required,help:Output table of the variants gained/lost/changed."`
+	SequencesA int     `arg:"--sequences-a,help:sequences in the first run; frequencies are of this (default the summed variant counts)."`
+	SequencesB int     `arg:"--sequences-b,help:sequences in the second run (default the summed variant counts)."`
+	Alpha      float64 `arg:"--alpha,help:false discovery rate for a change in frequency (default 0.05)."`
+	All        bool    `arg:"--all,help:also write the unchanged variants."`
+}
+
+func (c diffargs) Description() string {
+	return "Variants gained or lost or changed in frequency between two runs; two proportion z tests with Benjamini-Hochberg correction."
+}
+
+// ============================================================================
+/// Structs/Related functions
+// ============================================================================
+
+// Entry is a variant of a run; Key is the same for the same variant in any run
+type Entry struct {
+	ID     string
+	Key    string
+	Count  int
+	Desc   string      // prev/next anchors for tables, CHROM:POS:REF:ALT for vcfs
+	kmers  []string    // tables: prev, deviants, next
+	record *vcf.Record // vcfs
+}
+
+// Run is a variant table or classified vcf
+type Run struct {
+	Name    string
+	VCF     bool
+	K       int // tables only
+	header  *vcf.Header
+	Entries []*Entry
+}
+
+// ReadRun reads a variant table as written by tagvars or a vcf as written by
+// classify, told apart by the vcf fileformat line.  Table variants are keyed
+// by the stable ID of their kmers (seqmer.KmerVariantKey), vcf records by their
+// VID, or CHROM:POS:REF:ALT for vcfs without one.
+func ReadRun(path string) (*Run, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	run := &Run{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	reader := bufio.NewReader(f)
+	if start, _ := reader.Peek(len("##fileformat=VCF")); string(start) == "##fileformat=VCF" {
+		run.VCF = true
+		// the peek has read ahead into f, so go back to the start for the header
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		run.header = vcf.ParseVCFHeader(f) // back at the start of the file after
+		return run, run.readVCF(f)
+	}
+	return run, run.readTable(reader)
+}
+
+func (run *Run) readTable(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // long deviant lists
+	var ID, count, prev int
+	for linecount := 0; scanner.Scan(); linecount++ {
+		tokens := strings.Split(scanner.Text(), "\t")
+		if linecount == 0 { // Variant dataset ... k N
+			words := strings.Fields(tokens[0])
+			if len(words) > 1 && words[len(words)-2] == "k" {
+				run.K, _ = strconv.Atoi(words[len(words)-1])
+			}
+			continue
+		}
+		if linecount == 1 {
+			ID, count, prev = seqmer.Index(tokens, "VariantID"), seqmer.Index(tokens, "count"), seqmer.Index(tokens, "prev")
+			if ID < 0 || count < 0 || prev < 0 {
+				return fmt.Errorf("%s is not a variant table, no VariantID, count and prev columns", run.Name)
+			}
+			continue
+		}
+		kmers := make([]string, 0, 8)
+		for _, kmer := range tokens[prev:] {
+			if kmer == "" { // the trailing tab
+				break
+			}
+			kmers = append(kmers, kmer)
+		}
+		if len(kmers) < 3 {
+			return fmt.Errorf("variant %s in %s has no deviants", tokens[ID], run.Name)
+		}
+		n := len(kmers)
+		c, err := strconv.Atoi(tokens[count])
+		if err != nil {
+			return fmt.Errorf("variant %s in %s: %v", tokens[ID], run.Name, err)
+		}
+		run.Entries = append(run.Entries, &Entry{
+			ID: tokens[ID], Count: c,
+			Key:   seqmer.KmerVariantKey(strings.TrimPrefix(kmers[0], "-"), kmers[1:n-1], strings.TrimPrefix(kmers[n-1], "-")),
+			Desc:  kmers[0] + ">" + kmers[n-1],
+			kmers: kmers,
+		})
+	}
+	return scanner.Err()
+}
+
+func (run *Run) readVCF(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // long KMERS
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, err := vcf.ParseVCFRecord(line)
+		if err != nil {
+			return err
+		}
+		desc := fmt.Sprintf("%s:%d:%s:%s", record.Chrom, record.Pos, record.Ref, record.Alt)
+		key := desc
+		if vid := record.Info["VID"]; len(vid) == 1 {
+			key = vid[0]
+		} else if end := record.Info["END"]; strings.HasPrefix(record.Alt, "<") && len(end) == 1 {
+			key += ":" + end[0]
+		}
+		count := 1
+		if c := record.Info["COUNT"]; len(c) == 1 {
+			if count, err = strconv.Atoi(c[0]); err != nil {
+				return fmt.Errorf("record %s in %s: %v", record.ID, run.Name, err)
+			}
+		}
+		run.Entries = append(run.Entries, &Entry{ID: record.ID, Key: key, Count: count, Desc: desc, record: record})
+	}
+	return scanner.Err()
+}
+
+// Merged is several runs merged; Counts and IDs give each variant in each source
+type Merged struct {
+	Sources []string
+	VCF     bool
+	K       int
+	header  *vcf.Header
+	Entries []*MergedEntry
+}
+
+type MergedEntry struct {
+	*Entry
+	Counts []int
+	IDs    []string // "-" where the source doesn't have the variant
+}
+
+// Merge merges runs of one kind, summing the counts of variants with the same
+// key.  The first run keeps its IDs.  A variant new in a later run takes the
+// merged ID of another of the same ID in that run if there is one (as for the
+// records classify makes of one kmer variant), otherwise the next free ID.
+func Merge(runs []*Run) (*Merged, error) {
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("nothing to merge")
+	}
+	m := &Merged{VCF: runs[0].VCF, K: runs[0].K, header: runs[0].header}
+	for _, run := range runs {
+		if run.VCF != m.VCF {
+			return nil, fmt.Errorf("%s and %s are not both variant tables or vcfs", runs[0].Name, run.Name)
+		}
+		if !run.VCF && run.K != m.K {
+			return nil, fmt.Errorf("%s has k %d but %s has k %d; merge their classified vcfs instead",
+				run.Name, run.K, runs[0].Name, m.K)
+		}
+		m.Sources = append(m.Sources, run.Name)
+	}
+
+	nextID := 0
+	for _, entry := range runs[0].Entries {
+		if ID, err := strconv.Atoi(entry.ID); err == nil && ID > nextID {
+			nextID = ID
+		}
+	}
+	bykey := make(map[string]*MergedEntry)
+	for source, run := range runs {
+		mergedIDs := make(map[string]string) // the merged ID of each ID of this run
+		for _, entry := range run.Entries {
+			me := bykey[entry.Key]
+			if me == nil {
+				ID, ok := mergedIDs[entry.ID]
+				if !ok && source == 0 {
+					ID = entry.ID
+				} else if !ok {
+					nextID++
+					ID = strconv.Itoa(nextID)
+				}
+				copied := *entry
+				copied.ID, copied.Count = ID, 0
+				me = &MergedEntry{Entry: &copied, Counts: make([]int, len(runs)), IDs: make([]string, len(runs))}
+				for i := range me.IDs {
+					me.IDs[i] = "-"
+				}
+				bykey[entry.Key] = me
+				m.Entries = append(m.Entries, me)
+			}
+			if _, ok := mergedIDs[entry.ID]; !ok {
+				mergedIDs[entry.ID] = me.ID
+			}
+			me.Count += entry.Count
+			me.Counts[source] += entry.Count
+			me.IDs[source] = entry.ID
+		}
+	}
+	return m, nil
+}
+
+// ============================================================================
+/// Differences
+// ============================================================================
+
+// Change is a variant compared between two runs
+type Change struct {
+	*MergedEntry
+	FreqA  float64
+	FreqB  float64
+	Z      float64
+	P      float64
+	Q      float64 // Benjamini-Hochberg adjusted
+	Status string  // gained, lost, changed or same
+}
+
+// Diff compares the frequencies of the variants of runs a and b, out of
+// sequencesA and sequencesB sequences (0 for the summed variant counts), with
+// a two proportion z test of each variant.  Variants only in one run are
+// gained or lost; of the rest, those with an adjusted p value at most alpha
+// have changed.
+func Diff(a *Run, b *Run, sequencesA int, sequencesB int, alpha float64) ([]*Change, error) {
+	m, err := Merge([]*Run{a, b})
+	if err != nil {
+		return nil, err
+	}
+	totals := [2]int{sequencesA, sequencesB}
+	for i := range totals {
+		if totals[i] == 0 {
+			for _, me := range m.Entries {
+				totals[i] += me.Counts[i]
+			}
+		}
+	}
+	if totals[0] == 0 || totals[1] == 0 {
+		return nil, fmt.Errorf("a run has no variants to compare")
+	}
+	changes := make([]*Change, len(m.Entries))
+	pvalues := make([]float64, len(m.Entries))
+	for i, me := range m.Entries {
+		c := &Change{MergedEntry: me}
+		c.FreqA = float64(me.Counts[0]) / float64(totals[0])
+		c.FreqB = float64(me.Counts[1]) / float64(totals[1])
+		c.Z, c.P = zTest(me.Counts[0], totals[0], me.Counts[1], totals[1])
+		changes[i], pvalues[i] = c, c.P
+	}
+	for i, q := range benjaminiHochberg(pvalues) {
+		c := changes[i]
+		c.Q = q
+		switch {
+		case c.Counts[0] == 0:
+			c.Status = "gained"
+		case c.Counts[1] == 0:
+			c.Status = "lost"
+		case q <= alpha:
+			c.Status = "changed"
+		default:
+			c.Status = "same"
+		}
+	}
+	return changes, nil
+}
+
+// two proportion z test of x1 of n1 against x2 of n2, with the pooled
+// proportion; returns z (positive for an increase) and the two sided p
+func zTest(x1 int, n1 int, x2 int, n2 int) (float64, float64) {
+	p1, p2 := float64(x1)/float64(n1), float64(x2)/float64(n2)
+	pooled := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 0, 1
+	}
+	z := (p2 - p1) / se
+	return z, math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// Benjamini-Hochberg adjusted p values, in the order given
+func benjaminiHochberg(pvalues []float64) []float64 {
+	n := len(pvalues)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return pvalues[order[i]] < pvalues[order[j]] })
+	adjusted := make([]float64, n)
+	running := 1.0
+	for rank := n; rank >= 1; rank-- {
+		i := order[rank-1]
+		running = math.Min(running, pvalues[i]*float64(n)/float64(rank))
+		adjusted[i] = running
+	}
+	return adjusted
+}
+
+// ============================================================================
+/// Output
+// ============================================================================
+
+// Write writes the merged variants in the format of the sources: a variant
+// table as tagvars writes, or a vcf with each source's count in SOURCES
+func (m *Merged) Write(path string) {
+	out, err := os.Create(path)
+	Check(err)
+	defer out.Close()
+	if m.VCF {
+		m.header.AddInfo("SOURCES", ".", "Integer",
+			"Count in each merged source: "+strings.Join(m.Sources, " ")+".").
+			Write(out)
+		for _, me := range m.Entries {
+			record := *me.record
+			record.Info = make(map[string][]string, len(me.record.Info))
+			for k, v := range me.record.Info {
+				record.Info[k] = v
+			}
+			record.SetID(me.ID)
+			record.Info["COUNT"] = []string{strconv.Itoa(me.Count)}
+			counts := make([]string, len(me.Counts))
+			for i, c := range me.Counts {
+				counts[i] = strconv.Itoa(c)
+			}
+			record.AddInfo("SOURCES", counts...).Write(out)
+		}
+		return
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "Variant dataset merged", strings.Join(m.Sources, ","), "total variants", len(m.Entries),
+		"min to print", 0, "k", m.K)
+	fmt.Fprintln(w, "VariantID\torigID\tcount\tname\tVID\tdevnum\tprev\tdeviants\tnext")
+	for _, me := range m.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t\t%s\t%d\t%s\t\n", me.ID, me.ID, me.Count, me.Key, len(me.kmers)-2,
+			strings.Join(me.kmers, "\t"))
+	}
+}
+
+// WriteCounts writes the count and ID of each merged variant in each source
+func (m *Merged) WriteCounts(path string) {
+	out, err := os.Create(path)
+	Check(err)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	fmt.Fprintf(w, "VariantID\tkey\tvariant\tcount\t%s\t%s\n", strings.Join(m.Sources, "\t"),
+		strings.Join(m.Sources, "_ID\t")+"_ID")
+	for _, me := range m.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d", me.ID, me.Key, me.Desc, me.Count)
+		for _, c := range me.Counts {
+			fmt.Fprintf(w, "\t%d", c)
+		}
+		fmt.Fprintf(w, "\t%s\n", strings.Join(me.IDs, "\t"))
+	}
+}
+
+// WriteChanges writes the changes, all of them or only those not the same
+func WriteChanges(path string, changes []*Change, all bool) {
+	out, err := os.Create(path)
+	Check(err)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "VariantID\tkey\tvariant\tcountA\tcountB\tfreqA\tfreqB\tz\tp\tq\tstatus")
+	for _, c := range changes {
+		if c.Status == "same" && !all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.6g\t%.6g\t%.4f\t%.4g\t%.4g\t%s\n", c.ID, c.Key, c.Desc,
+			c.Counts[0], c.Counts[1], c.FreqA, c.FreqB, c.Z, c.P, c.Q, c.Status)
+	}
+}
+
+func readRuns(p *arg.Parser, paths ...string) []*Run {
+	runs := make([]*Run, len(paths))
+	for i, path := range paths {
+		abspath, err := filepath.Abs(path)
+		Check(err)
+		if runs[i], err = ReadRun(abspath); err != nil {
+			p.Fail(err.Error())
+		}
+	}
+	return runs
+}
+
+func MergeMain() {
+	cli := mergeargs{}
+	p := arg.MustParse(&cli)
+	m, err := Merge(readRuns(p, cli.Infiles...))
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	outpath, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	m.Write(outpath)
+	if cli.Counts != "" {
+		countpath, err := filepath.Abs(cli.Counts)
+		Check(err)
+		m.WriteCounts(countpath)
+	}
+}
+
+func DiffMain() {
+	cli := diffargs{}
+	p := arg.MustParse(&cli)
+	if cli.Alpha == 0 {
+		cli.Alpha = 0.05
+	}
+	runs := readRuns(p, cli.Before, cli.After)
+	changes, err := Diff(runs[0], runs[1], cli.SequencesA, cli.SequencesB, cli.Alpha)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	outpath, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	WriteChanges(outpath, changes, cli.All)
+}
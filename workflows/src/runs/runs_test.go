@@ -0,0 +1,138 @@
+package runs_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"annotation/runs"
+	. "annotation/utils"
+	"annotation/vcf"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func readRuns(files ...string) []*runs.Run {
+	read := make([]*runs.Run, len(files))
+	for i, file := range files {
+		path, _ := filepath.Abs(file)
+		run, err := runs.ReadRun(path)
+		Check(err)
+		read[i] = run
+	}
+	return read
+}
+
+func TestMergeTables(t *testing.T) {
+	m, err := runs.Merge(readRuns("test_data/a.xls", "test_data/b.xls"))
+	Check(err)
+	compare(m.Sources, []string{"a", "b"}, t)
+	ids, counts, sources, sourceIDs := []string{}, []int{}, [][]int{}, [][]string{}
+	for _, me := range m.Entries {
+		ids = append(ids, me.ID)
+		counts = append(counts, me.Count)
+		sources = append(sources, me.Counts)
+		sourceIDs = append(sourceIDs, me.IDs)
+	}
+	compare(ids, []string{"1", "2", "3", "4"}, t)
+	compare(counts, []int{22, 5, 65, 4}, t)
+	compare(sources, [][]int{{10, 12}, {5, 0}, {20, 45}, {0, 4}}, t)
+	compare(sourceIDs, [][]string{{"1", "3"}, {"2", "-"}, {"3", "1"}, {"-", "2"}}, t)
+
+	t.Run("written table reads back", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "merged.xls")
+		m.Write(path)
+		run, err := runs.ReadRun(path)
+		Check(err)
+		compare(run.K, 5, t)
+		compare(len(run.Entries), 4, t)
+		compare(run.Entries[3].Key, m.Entries[3].Key, t)
+		compare(run.Entries[2].Count, 65, t)
+	})
+	t.Run("different k", func(t *testing.T) {
+		data, err := os.ReadFile("test_data/b.xls")
+		Check(err)
+		path := filepath.Join(t.TempDir(), "c.xls")
+		Check(os.WriteFile(path, []byte(strings.Replace(string(data), "k 5", "k 7", 1)), 0644))
+		c, err := runs.ReadRun(path)
+		Check(err)
+		if _, err := runs.Merge(append(readRuns("test_data/a.xls"), c)); err == nil {
+			t.Error("expected an error merging tables of different k")
+		}
+	})
+	t.Run("tables and vcfs", func(t *testing.T) {
+		if _, err := runs.Merge(readRuns("test_data/a.xls", "test_data/a.vcf")); err == nil {
+			t.Error("expected an error merging a table and a vcf")
+		}
+	})
+}
+
+func TestMergeVCFs(t *testing.T) {
+	m, err := runs.Merge(readRuns("test_data/a.vcf", "test_data/b.vcf"))
+	Check(err)
+	ids, keys := []string{}, []string{}
+	for _, me := range m.Entries {
+		ids = append(ids, me.ID)
+		keys = append(keys, me.Key)
+	}
+	// both records of ID 2 in b are new, so share the merged ID 3
+	compare(ids, []string{"1", "2", "3", "3", "4"}, t)
+	compare(keys, []string{"aaaa", "bbbb", "cccc", "dddd", "contig:40:A:G"}, t)
+
+	path := filepath.Join(t.TempDir(), "merged.vcf")
+	m.Write(path)
+	data, err := os.ReadFile(path)
+	Check(err)
+	// the header of the first source is kept, with SOURCES added
+	for _, line := range []string{"##reference=ref.fa", "##contig=<ID=contig,length=100>",
+		"##INFO=<ID=COUNT,", "##INFO=<ID=VID,", "##INFO=<ID=SOURCES,"} {
+		if !strings.Contains(string(data), "\n"+line) {
+			t.Errorf("merged header is missing %s", line)
+		}
+	}
+	records := make([]*vcf.Record, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			record, err := vcf.ParseVCFRecord(line)
+			Check(err)
+			records = append(records, record)
+		}
+	}
+	compare(len(records), 5, t)
+	compare(records[1].Info["COUNT"], []string{"10"}, t)
+	compare(records[1].Info["SOURCES"], []string{"7", "3"}, t)
+	compare(records[4].ID, "4", t)
+}
+
+func TestDiff(t *testing.T) {
+	read := readRuns("test_data/a.xls", "test_data/b.xls")
+	changes, err := runs.Diff(read[0], read[1], 100, 100, 0.05)
+	Check(err)
+	statuses := []string{}
+	for _, c := range changes {
+		statuses = append(statuses, c.Status)
+	}
+	compare(statuses, []string{"same", "lost", "changed", "gained"}, t)
+	compare([]float64{changes[2].FreqA, changes[2].FreqB}, []float64{0.2, 0.45}, t)
+	if changes[2].Z <= 0 {
+		t.Errorf("expected a positive z for an increase, got %f", changes[2].Z)
+	}
+	// Benjamini-Hochberg: the lost and gained variants rank 2nd and 3rd of 4,
+	// with p values of 0.0236 and 0.0434, adjusted by 4/2 and 4/3
+	if math.Abs(changes[1].Q-0.0472) > 0.001 || math.Abs(changes[3].Q-0.0579) > 0.001 {
+		t.Errorf("expected adjusted p values of 0.0472 and 0.0579, got %f and %f", changes[1].Q, changes[3].Q)
+	}
+
+	t.Run("default totals", func(t *testing.T) {
+		changes, err := runs.Diff(read[0], read[1], 0, 0, 0.05)
+		Check(err)
+		compare([]float64{changes[1].FreqA, changes[1].FreqB}, []float64{5.0 / 35, 0}, t)
+	})
+}
@@ -0,0 +1,59 @@
+// Haplotype trees from the haplotype files written by seqmer.
+package tree
+
+import (
+	"path/filepath"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	. "annotation/utils"
+)
+
+type cliargs struct {
+	Haplotypes string `arg:"--haplotypes,required,help:haplotype file as written by haploscan."`
+	MinCount   int    `arg:"--min-count,help:leave out haplotypes seen fewer times than this."`
+	Parsimony  bool   `arg:"--parsimony,help:map the variants gained and lost onto the branches by Fitch parsimony."`
+	Newick     string `arg:"--newick,help:Output Newick tree."`
+	Nexus      string `arg:"--nexus,help:Output Nexus tree with the variants of each haplotype."`
+}
+
+func (c cliargs) Description() string {
+	return "Neighbor joining tree of haplotypes, on the number of variants that differ."
+}
+
+// Build reads the haplotypes in hapfile and builds a neighbor joining tree of
+// those seen at least mincount times, optionally mapping the variants onto
+// its branches by parsimony.
+func Build(hapfile string, mincount int, parsimony bool) *seqmer.Haptree {
+	haps := new(seqmer.Haplotypes)
+	haps.Init(0, "", 0)
+	haps.Read(hapfile)
+	tree := haps.Tree(mincount)
+	if parsimony {
+		tree.Parsimony()
+	}
+	return tree
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+	if cli.Newick == "" && cli.Nexus == "" {
+		p.Fail("no output, use --newick and/or --nexus")
+	}
+
+	hapfile, err := filepath.Abs(cli.Haplotypes)
+	Check(err)
+	tree := Build(hapfile, cli.MinCount, cli.Parsimony)
+	if cli.Newick != "" {
+		treefile, err := filepath.Abs(cli.Newick)
+		Check(err)
+		tree.NewickPrint(treefile)
+	}
+	if cli.Nexus != "" {
+		treefile, err := filepath.Abs(cli.Nexus)
+		Check(err)
+		tree.NexusPrint(treefile)
+	}
+}
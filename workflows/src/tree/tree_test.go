@@ -0,0 +1,47 @@
+package tree_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"annotation/tree"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+
+	t.Run("neighbor joining", func(t *testing.T) {
+		// rooted on the reference haplotype h0, no parsimony labels
+		correct := "(h0[&count=10]:0.5,(h1[&count=5]:1,(h3[&count=3]:1,h2[&count=4]:1):0):0.5);"
+		compare(tree.Build(hapfile, 2, false).Newick(), correct, t)
+	})
+	t.Run("parsimony", func(t *testing.T) {
+		// variant 1 is shared, so is gained once on the branch to h1, h2 and h3
+		haptree := tree.Build(hapfile, 2, true)
+		correct := `(h0[&count=10]:0.5,(h1[&count=5,gained="2"]:1,(h3[&count=3,gained="4"]:1,h2[&count=4,gained="3"]:1):0)[&gained="1"]:0.5);`
+		compare(haptree.Newick(), correct, t)
+		compare(haptree.Score, 4, t)
+		compare(tree.Build(hapfile, 1, true).Score, 5, t)
+	})
+	t.Run("nexus", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tree.nex")
+		tree.Build(hapfile, 2, false).NexusPrint(path)
+		data, err := os.ReadFile(path)
+		Check(err)
+		for _, want := range []string{"#NEXUS", "DIMENSIONS NTAX=4;", `h1[&count=5,variants="1,2"]`, "TREE haplotypes = [&R] (h0"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("missing %q in\n%s", want, data)
+			}
+		}
+	})
+}
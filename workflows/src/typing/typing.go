@@ -0,0 +1,233 @@
+// Typing of single sequences against a saved reference kmer set and variant
+// catalogue, with the nearest known haplotype and lineage, without rerunning
+// haploscan over the cohort.
+package typing
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+
+	"AnVir/seqmer"
+	"annotation/lineage"
+	. "annotation/utils"
+)
+
+type cliargs struct {
+	Kmers       string  `arg:"--kmers,required,help:reference .kcounts file (tab separated or binary)."`
+	Variants    string  `arg:"--variants,required,help:known variant catalogue as written by tagvars."`
+	Sequences   string  `arg:"--sequences,required,help:fasta of the sequences to type."`
+	Haplotypes  string  `arg:"--haplotypes,help:known haplotypes as written by haploscan; for the nearest haplotype."`
+	Definitions string  `arg:"--definitions,help:lineage definitions (see anvir lineage); needs --vcf."`
+	VCF         string  `arg:"--vcf,help:the catalogue variants as written by classify; for lineages."`
+	MinFraction float64 `arg:"--min-fraction,help:lineages need at least this fraction of their defining mutations present (default 0.8)."`
+	Outfile     string  `arg:"--outfile,required,help:Output typing of each sequence."`
+	Novel       string  `arg:"--novel,help:Output the novel non-reference stretches of each sequence."`
+}
+
+func (c cliargs) Description() string {
+	return "Type sequences by their known variants and novel stretches, nearest haplotype and lineage."
+}
+
+// ============================================================================
+/// Structs/Related functions
+// ============================================================================
+
+// Typer holds what sequences are typed against, loaded once
+type Typer struct {
+	typer       *seqmer.Typer
+	definitions *lineage.Definitions
+	mutations   map[int][]string
+	minfraction float64
+}
+
+// Result is the typing of a sequence, with its lineage when definitions are given
+type Result struct {
+	*seqmer.Seqtype
+	Lineage lineage.Assignment
+}
+
+// Load reads the reference kmers and variant catalogue, and the haplotypes if
+// hapfile isn't ""
+func Load(kmerfile string, varfile string, hapfile string) *Typer {
+	refmers := new(seqmer.Oligos)
+	refmers.Init(0, "", false, 0) // klen taken from the file
+	refmers.Readk(kmerfile)
+
+	vars := new(seqmer.Variants)
+	vars.Init(0, "", 0)
+	vars.Read(varfile, 0)
+
+	var haps *seqmer.Haplotypes
+	if hapfile != "" {
+		haps = new(seqmer.Haplotypes)
+		haps.Init(0, "", 0)
+		haps.Read(hapfile)
+	}
+	t := &Typer{typer: new(seqmer.Typer)}
+	t.typer.Init(refmers, vars, haps)
+	return t
+}
+
+// SetLineages assigns lineages from the definitions, with the mutations of the
+// catalogue variants read from a classify vcf
+func (t *Typer) SetLineages(defpath string, vcfpath string, minfraction float64) error {
+	definitions, err := lineage.ReadDefinitions(defpath)
+	if err != nil {
+		return err
+	}
+	mutations, err := lineage.ReadVariantMutations(vcfpath)
+	if err != nil {
+		return err
+	}
+	t.definitions, t.mutations, t.minfraction = definitions, mutations, minfraction
+	return nil
+}
+
+// Type types one sequence
+func (t *Typer) Type(name string, seq string) Result {
+	r := Result{Seqtype: t.typer.Type(name, seq)}
+	if t.definitions != nil {
+		r.Lineage = t.definitions.Assign(lineage.Sample{Name: name, Count: 1, Variants: r.Known}, t.mutations, t.minfraction)
+	}
+	return r
+}
+
+// TypeFasta types each sequence of a fasta file in turn, calling found with
+// each result
+func (t *Typer) TypeFasta(path string, found func(Result)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var name string
+	var seq strings.Builder
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // single line genomes
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, ">") {
+			if name != "" {
+				found(t.Type(name, seq.String()))
+			}
+			name = strings.TrimPrefix(line, ">")
+			seq.Reset()
+		} else {
+			seq.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if name != "" {
+		found(t.Type(name, seq.String()))
+	}
+	return nil
+}
+
+// ============================================================================
+/// Output
+// ============================================================================
+
+// a comma separated list of IDs, - when empty
+func idlist(IDs []int) string {
+	if len(IDs) == 0 {
+		return "-"
+	}
+	words := make([]string, len(IDs))
+	for i, ID := range IDs {
+		words[i] = strconv.Itoa(ID)
+	}
+	return strings.Join(words, ",")
+}
+
+// writes a result per line, with the lineage columns "-" without definitions
+func writeResult(w *bufio.Writer, r Result) {
+	nearest, lineagename, fraction := "-", "-", "-"
+	if r.Nearest >= 0 {
+		nearest = strconv.Itoa(r.Nearest)
+	}
+	if r.Lineage.Lineage != "" {
+		lineagename, fraction = r.Lineage.Lineage, fmt.Sprintf("%.4f", r.Lineage.Fraction)
+	}
+	fmt.Fprintf(w, "%s\t%t\t%d\t%s\t%d\t%d\t%s\t%d\t%s\t%s\n", r.Name, r.Flipped, len(r.Known), idlist(r.Known),
+		len(r.Novel), r.Masked, nearest, r.Distance, lineagename, fraction)
+}
+
+func writeNovel(w *bufio.Writer, r Result) {
+	for _, stretch := range r.Novel {
+		bases := stretch.Bases
+		if bases == "" {
+			bases = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\n", r.Name, stretch.Offset, stretch.Prev, stretch.Next, stretch.Deviants, bases)
+	}
+}
+
+func Main() {
+	cli := cliargs{}
+	p := arg.MustParse(&cli)
+	if (cli.Definitions == "") != (cli.VCF == "") {
+		p.Fail("lineages need both --definitions and --vcf")
+	}
+	if cli.MinFraction == 0 {
+		cli.MinFraction = 0.8
+	}
+
+	kmerfile, err := filepath.Abs(cli.Kmers)
+	Check(err)
+	varfile, err := filepath.Abs(cli.Variants)
+	Check(err)
+	hapfile := ""
+	if cli.Haplotypes != "" {
+		hapfile, err = filepath.Abs(cli.Haplotypes)
+		Check(err)
+	}
+	t := Load(kmerfile, varfile, hapfile)
+	if cli.Definitions != "" {
+		defpath, err := filepath.Abs(cli.Definitions)
+		Check(err)
+		vcfpath, err := filepath.Abs(cli.VCF)
+		Check(err)
+		if err := t.SetLineages(defpath, vcfpath, cli.MinFraction); err != nil {
+			p.Fail(err.Error())
+		}
+	}
+
+	outpath, err := filepath.Abs(cli.Outfile)
+	Check(err)
+	out, err := os.Create(outpath)
+	Check(err)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "sequence\tflipped\tnknown\tknown\tnnovel\tmasked\tnearest\tdistance\tlineage\tfraction")
+
+	var nw *bufio.Writer
+	if cli.Novel != "" {
+		novelpath, err := filepath.Abs(cli.Novel)
+		Check(err)
+		novel, err := os.Create(novelpath)
+		Check(err)
+		defer novel.Close()
+		nw = bufio.NewWriter(novel)
+		defer nw.Flush()
+		fmt.Fprintln(nw, "sequence\toffset\tprev\tnext\tdeviants\tbases")
+	}
+
+	seqpath, err := filepath.Abs(cli.Sequences)
+	Check(err)
+	Check(t.TypeFasta(seqpath, func(r Result) {
+		writeResult(w, r)
+		if nw != nil {
+			writeNovel(nw, r)
+		}
+	}))
+}
@@ -0,0 +1,63 @@
+package typing_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"AnVir/seqmer"
+	"annotation/typing"
+	. "annotation/utils"
+)
+
+func compare[T any](result T, correct T, t *testing.T) {
+	if !reflect.DeepEqual(result, correct) {
+		t.Errorf("\ncorrect: %+v\nresult: %+v\n", correct, result)
+	}
+}
+
+func TestType(t *testing.T) {
+	kmerfile, _ := filepath.Abs("test_data/reference.kcounts")
+	varfile, _ := filepath.Abs("test_data/variants.xls")
+	hapfile, _ := filepath.Abs("test_data/haplotypes.xls")
+	defpath, _ := filepath.Abs("test_data/clades.tsv")
+	vcfpath, _ := filepath.Abs("test_data/variants.vcf")
+	seqpath, _ := filepath.Abs("test_data/sequences.fasta")
+
+	typer := typing.Load(kmerfile, varfile, hapfile)
+	Check(typer.SetLineages(defpath, vcfpath, 0.8))
+	results := make(map[string]typing.Result)
+	names := make([]string, 0)
+	Check(typer.TypeFasta(seqpath, func(r typing.Result) {
+		results[r.Name] = r
+		names = append(names, r.Name)
+	}))
+	compare(names, []string{"reference", "known", "both", "novel", "reversed", "masked"}, t)
+
+	t.Run("known variants", func(t *testing.T) {
+		compare(results["reference"].Known, []int(nil), t)
+		compare(results["known"].Known, []int{1}, t)
+		compare(results["both"].Known, []int{1, 2}, t)
+	})
+	t.Run("nearest haplotype and lineage", func(t *testing.T) {
+		compare([]int{results["reference"].Nearest, results["known"].Nearest, results["both"].Nearest}, []int{0, 1, 2}, t)
+		compare([]string{results["reference"].Lineage.Lineage, results["known"].Lineage.Lineage, results["both"].Lineage.Lineage},
+			[]string{"root", "A", "B"}, t)
+	})
+	t.Run("novel", func(t *testing.T) {
+		r := results["novel"]
+		compare(r.Known, []int{1}, t)
+		compare(r.Novel, []*seqmer.Stretch{{Offset: 52, Prev: "GTGAATCG", Next: "TTAAGGGT", Deviants: 8, Bases: "A"}}, t)
+		compare([]int{r.Nearest, r.Distance}, []int{1, 0}, t)
+	})
+	t.Run("reverse complement", func(t *testing.T) {
+		r := results["reversed"]
+		compare(r.Flipped, true, t)
+		compare(r.Known, []int{1, 2}, t)
+	})
+	t.Run("masked", func(t *testing.T) {
+		r := results["masked"]
+		compare([]int{r.Masked, len(r.Novel)}, []int{1, 0}, t)
+		compare(r.Known, []int{1}, t)
+	})
+}
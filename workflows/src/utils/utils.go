@@ -78,6 +78,10 @@ func Min(a int, b int) int {
 	if a < b { return a }
 	return b
 }
+func Minf(a float64, b float64) float64 {
+	if a < b { return a }
+	return b
+}
 
 // needlman wunch alignment for DNA or amino acid sequence
 // (specified by dna flag). We expect sequences to be short.
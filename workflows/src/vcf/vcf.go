@@ -29,9 +29,17 @@ type InfoHeader struct {
 	Description string
 }
 
+// used for both ##FILTER and ##ALT (symbolic allele) lines
+type DescHeader struct {
+	ID string
+	Description string
+}
+
 type Header struct {
 	Contigs []ContigHeader
 	Info []InfoHeader
+	Filters []DescHeader
+	Alts []DescHeader
 	Reference string // name or path of reference genome
 }
 
@@ -77,6 +85,20 @@ func ParseVCFHeader(v *os.File) *Header {
 					}
 				}
 				header.Contigs = append(header.Contigs, contig)
+			case "##FILTER", "##ALT":
+				desc := DescHeader{}
+				for _, items := range strings.Split(rest[1:len(rest)-1], ",") {
+					key, value, _ := strings.Cut(items, "=")
+					switch key {
+					case "ID": desc.ID = value
+					case "Description": desc.Description = value[1:len(value)-1]
+					}
+				}
+				if field == "##FILTER" {
+					header.Filters = append(header.Filters, desc)
+				} else {
+					header.Alts = append(header.Alts, desc)
+				}
 			}
 		} else {
 			break
@@ -105,6 +127,17 @@ func (hd *Header)AddInfo(id string, number string,
 	return hd
 }
 
+func (hd *Header)AddFilter(id string, desc string) *Header {
+	hd.Filters = append(hd.Filters, DescHeader{ID: id, Description: desc})
+	return hd
+}
+
+// declare a symbolic ALT allele, eg AddAlt("DEL", ...) for <DEL>
+func (hd *Header)AddAlt(id string, desc string) *Header {
+	hd.Alts = append(hd.Alts, DescHeader{ID: id, Description: desc})
+	return hd
+}
+
 func (hd *Header)Write(f *os.File) {
 	f.WriteString("##fileformat=VCFv4.3\n")
 	fmt.Fprintf(f, "##reference=%s\n", hd.Reference)
@@ -112,6 +145,12 @@ func (hd *Header)Write(f *os.File) {
 		fmt.Fprintf(f, "##INFO=<ID=%s,Number=%s,Type=%s,Description=\"%s\">\n",
 			i.ID, i.Number, i.Type, i.Description)
 	}
+	for _, i := range hd.Filters {
+		fmt.Fprintf(f, "##FILTER=<ID=%s,Description=\"%s\">\n", i.ID, i.Description)
+	}
+	for _, i := range hd.Alts {
+		fmt.Fprintf(f, "##ALT=<ID=%s,Description=\"%s\">\n", i.ID, i.Description)
+	}
 	for _, c := range hd.Contigs {
 		fmt.Fprintf(f, "##contig=<ID=%s,length=%d>\n", c.ID, c.Length)
 	}
@@ -238,3 +277,29 @@ func ParseVCFRecord(line string) (*Record, error) {
 		SetFilter(fields[6]).
 		AddInfoFromString(fields[7]), nil
 }
+
+// read the position of each variant with a numeric ID, as written by classify
+func ReadIDPositions(vcffile string) (map[int]int, error) {
+	f, err := os.Open(vcffile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	positions := make(map[int]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, err := ParseVCFRecord(line)
+		if err != nil {
+			return nil, err
+		}
+		if id, err := strconv.Atoi(record.ID); err == nil {
+			positions[id] = record.Pos
+		}
+	}
+	return positions, scanner.Err()
+}